@@ -0,0 +1,167 @@
+package bridge
+
+import (
+	"io"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// Encrypted object data is sealed in chunks of this many plaintext bytes, so
+// it can be encrypted and decrypted as a stream
+const encryptChunkSize = 64 * 1024
+
+// Additional data authenticated with each chunk. Marking the final chunk
+// means truncated data fails to decrypt instead of silently coming up short.
+var middleChunk = []byte{0}
+var finalChunk = []byte{1}
+
+// Returns an AES-GCM cipher for the key, which must be 16, 24 or 32 bytes
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns a random base nonce for a new object
+func newNonce(aead cipher.AEAD) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	return nonce, err
+}
+
+// Returns the nonce for a chunk: the object's base nonce with the chunk
+// number XORed into its last 8 bytes
+func chunkNonce(base []byte, chunk uint64) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+	tail := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^chunk)
+	return nonce
+}
+
+// encryptWriter seals everything written to it in chunks and writes them to
+// the underlying writer. Close must be called to write the final chunk.
+type encryptWriter struct {
+	w io.Writer
+	aead cipher.AEAD
+	nonce []byte
+	chunk uint64
+	buf []byte
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// A full chunk stays buffered until more data arrives, since it
+		// may turn out to be the final one
+		if len(e.buf) == encryptChunkSize {
+			err := e.seal(middleChunk)
+			if err != nil {
+				return n - len(p), err
+			}
+		}
+
+		take := encryptChunkSize - len(e.buf)
+		if take > len(p) {
+			take = len(p)
+		}
+		e.buf = append(e.buf, p[:take]...)
+		p = p[take:]
+	}
+	return n, nil
+}
+
+// Writes the final chunk. Doesn't close the underlying writer.
+func (e *encryptWriter) Close() error {
+	return e.seal(finalChunk)
+}
+
+func (e *encryptWriter) seal(ad []byte) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.nonce, e.chunk), e.buf, ad)
+	e.chunk++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// decryptReader opens the chunks written by an encryptWriter as they're read
+type decryptReader struct {
+	r io.Reader
+	aead cipher.AEAD
+	nonce []byte
+	chunk uint64
+	buf []byte 		// Sealed chunk, plus one byte of the next chunk if any
+	have int 		// Bytes of buf carried over from the last read
+	out []byte
+	plain []byte 	// Decrypted bytes not yet returned
+	done bool
+}
+
+func newDecryptReader(r io.Reader, aead cipher.AEAD, nonce []byte) *decryptReader {
+	return &decryptReader{
+		r: r,
+		aead: aead,
+		nonce: nonce,
+		buf: make([]byte, encryptChunkSize+aead.Overhead()+1),
+		out: make([]byte, 0, encryptChunkSize),
+	}
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		err := d.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// Reads and decrypts the next chunk
+func (d *decryptReader) open() error {
+	// Read one byte past a full chunk to find out whether it's the final one
+	sealedSize := len(d.buf) - 1
+	n, err := io.ReadFull(d.r, d.buf[d.have:])
+	n += d.have
+
+	final := false
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		final = true
+	default:
+		return err
+	}
+
+	sealed := d.buf[:n]
+	ad := finalChunk
+	if !final {
+		sealed = d.buf[:sealedSize]
+		ad = middleChunk
+	}
+
+	plain, err := d.aead.Open(d.out[:0], chunkNonce(d.nonce, d.chunk), sealed, ad)
+	if err != nil {
+		return err
+	}
+	d.chunk++
+	d.plain = plain
+
+	if final {
+		d.done = true
+	} else {
+		d.buf[0] = d.buf[sealedSize]
+		d.have = 1
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package bridge
+
+import (
+	"fmt"
+	"database/sql"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Statements creating the original buckets and objects tables for each SQL
+// dialect. Later changes to the schema are made by schemaMigrations.
+var baseSchemas = map[string][]string{
+	"sqlite": {
+		"CREATE TABLE IF NOT EXISTS buckets(name TEXT PRIMARY KEY, created INTEGER)",
+		"CREATE TABLE IF NOT EXISTS objects(bucket TEXT, name TEXT, size INTEGER, queued INTEGER, uploaded INTEGER, purge_after INTEGER, cached_fetches INTEGER, sia_fetches INTEGER, last_fetch INTEGER, PRIMARY KEY(bucket,name) )",
+	},
+	"mysql": {
+		"CREATE TABLE IF NOT EXISTS buckets(name VARCHAR(255) PRIMARY KEY, created BIGINT)",
+		"CREATE TABLE IF NOT EXISTS objects(bucket VARCHAR(255), name VARCHAR(255), size BIGINT, queued BIGINT, uploaded BIGINT, purge_after BIGINT, cached_fetches BIGINT, sia_fetches BIGINT, last_fetch BIGINT, PRIMARY KEY(bucket,name) )",
+	},
+	"postgres": {
+		"CREATE TABLE IF NOT EXISTS buckets(name TEXT PRIMARY KEY, created BIGINT)",
+		"CREATE TABLE IF NOT EXISTS objects(bucket TEXT, name TEXT, size BIGINT, queued BIGINT, uploaded BIGINT, purge_after BIGINT, cached_fetches BIGINT, sia_fetches BIGINT, last_fetch BIGINT, PRIMARY KEY(bucket,name) )",
+	},
+}
+
+// Returns the SQL dialect spoken by a database/sql driver
+func sqlDialect(driver string) string {
+	switch driver {
+	case "mysql":
+		return "mysql"
+	case "postgres", "pgx":
+		return "postgres"
+	default:
+		return "sqlite"
+	}
+}
+
+// Wraps the database connection so that statements written with "?"
+// placeholders also work with drivers that number their placeholders.
+type database struct {
+	*sql.DB
+	numbered bool
+}
+
+func (d *database) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return d.DB.Exec(rebind(query, d.numbered), args...)
+}
+
+func (d *database) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return d.DB.Query(rebind(query, d.numbered), args...)
+}
+
+func (d *database) QueryRow(query string, args ...interface{}) *sql.Row {
+	return d.DB.QueryRow(rebind(query, d.numbered), args...)
+}
+
+func (d *database) Prepare(query string) (*sql.Stmt, error) {
+	return d.DB.Prepare(rebind(query, d.numbered))
+}
+
+func (d *database) Begin() (*transaction, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{Tx: tx, numbered: d.numbered}, nil
+}
+
+// Transaction counterpart of database
+type transaction struct {
+	*sql.Tx
+	numbered bool
+}
+
+func (t *transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.Exec(rebind(query, t.numbered), args...)
+}
+
+func (t *transaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.Query(rebind(query, t.numbered), args...)
+}
+
+func (t *transaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRow(rebind(query, t.numbered), args...)
+}
+
+// Returns whether err is a violation of a primary key or unique constraint.
+// Drivers don't share an error type for this, so each one's message is checked.
+func isUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "UNIQUE constraint failed") || // sqlite3
+		strings.Contains(msg, "Duplicate entry") || // mysql
+		strings.Contains(msg, "duplicate key value") // postgres
+}
+
+// Rewrites "?" placeholders as "$1", "$2", etc. when numbered is set. None of
+// the bridge's statements contain a literal question mark.
+func rebind(query string, numbered bool) string {
+	if !numbered {
+		return query
+	}
+
+	var out strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n += 1
+			out.WriteString("$" + strconv.Itoa(n))
+		} else {
+			out.WriteRune(c)
+		}
+	}
+	return out.String()
+}
+
+// Returns a LIKE pattern, for use with ESCAPE '!', matching strings that begin
+// with prefix. Escaping with '!' rather than a backslash works the same in
+// every supported database.
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return r.Replace(prefix) + "%"
+}
+
+// Schema changes applied on top of the original buckets and objects tables,
+// in order. A database's schema version is the number of these it has had
+// applied. Never edit or reorder existing entries; only append new ones.
+// Statements must work in every dialect in baseSchemas, unless they're
+// replaced for a dialect in dialectMigrations.
+var schemaMigrations = []string{
+	// 1: Per-object cache directory. Empty means the bridge's CacheDir.
+	"ALTER TABLE objects ADD COLUMN cache_dir VARCHAR(1024) DEFAULT ''",
+
+	// 2-3: Sia path of each object. Existing objects were uploaded using the
+	// unescaped bucket and object name.
+	"ALTER TABLE objects ADD COLUMN sia_path TEXT",
+	"UPDATE objects SET sia_path = bucket || '/' || name",
+
+	// 4-5: Unset timestamps are NULL rather than 0 (never uploaded) or -1
+	// (never fetched)
+	"UPDATE objects SET uploaded = NULL WHERE uploaded = 0",
+	"UPDATE objects SET last_fetch = NULL WHERE last_fetch < 0",
+
+	// 6: Time before which an object can't be deleted
+	"ALTER TABLE objects ADD COLUMN retain_until BIGINT",
+
+	// 7-8: Timestamps are milliseconds rather than seconds since the epoch
+	"UPDATE buckets SET created = created * 1000",
+	"UPDATE objects SET queued = queued * 1000, uploaded = uploaded * 1000, last_fetch = last_fetch * 1000, retain_until = retain_until * 1000",
+
+	// 9-11: Optional gzip compression of object data, and the size of the
+	// data as stored, which is the object's size unless it's compressed
+	"ALTER TABLE objects ADD COLUMN compressed INTEGER DEFAULT 0",
+	"ALTER TABLE objects ADD COLUMN stored_size BIGINT DEFAULT 0",
+	"UPDATE objects SET stored_size = size",
+
+	// 12: Hex encoded nonce of encrypted objects. Empty if not encrypted.
+	"ALTER TABLE objects ADD COLUMN nonce VARCHAR(64) DEFAULT ''",
+
+	// 13-14: Multipart uploads and the parts uploaded for them so far
+	"CREATE TABLE multipart_uploads(id VARCHAR(64) PRIMARY KEY, bucket VARCHAR(255), name VARCHAR(255), created BIGINT)",
+	"CREATE TABLE multipart_parts(upload_id VARCHAR(64), part_number INTEGER, size BIGINT, PRIMARY KEY(upload_id,part_number))",
+
+	// 15: Maximum total size of a bucket's objects. 0 means no limit.
+	"ALTER TABLE buckets ADD COLUMN quota_bytes BIGINT DEFAULT 0",
+
+	// 16: Maximum number of objects in a bucket. 0 means no limit.
+	"ALTER TABLE buckets ADD COLUMN max_objects BIGINT DEFAULT 0",
+
+	// 17-19: Time each object's record last changed, and tombstones of
+	// deleted objects, for incremental syncing
+	"ALTER TABLE objects ADD COLUMN modified BIGINT DEFAULT 0",
+	"UPDATE objects SET modified = COALESCE(uploaded, queued)",
+	"CREATE TABLE deleted_objects(bucket VARCHAR(255), name VARCHAR(255), deleted BIGINT)",
+
+	// 20: Audit log of operations on objects
+	"CREATE TABLE object_events(at BIGINT, type VARCHAR(16), bucket VARCHAR(255), name VARCHAR(255), detail TEXT)",
+
+	// 21: Objects kept in the cache until unpinned
+	"ALTER TABLE objects ADD COLUMN pinned INTEGER DEFAULT 0",
+
+	// 22-23: Permanent object IDs that survive renames. Existing objects are
+	// given IDs by assignObjectIDs.
+	"ALTER TABLE objects ADD COLUMN id VARCHAR(32) DEFAULT ''",
+	"CREATE INDEX objects_id ON objects(id)",
+
+	// 24-25: Time after which an object is deleted by the manager. NULL if it
+	// never expires.
+	"ALTER TABLE objects ADD COLUMN expires_at BIGINT",
+	"CREATE INDEX objects_expires_at ON objects(expires_at)",
+
+	// 26: Visibility of each object for a frontend to enforce
+	"ALTER TABLE objects ADD COLUMN acl VARCHAR(32) DEFAULT 'private'",
+
+	// 27: Object count and sizes recorded every StatsInterval
+	"CREATE TABLE stats_history(at BIGINT, objects BIGINT, bytes BIGINT, cache_bytes BIGINT)",
+
+	// 28: Finding uploads that have been pending since before a given time
+	"CREATE INDEX objects_uploaded_queued ON objects(uploaded, queued)",
+
+	// 29: Free-text description of each object
+	"ALTER TABLE objects ADD COLUMN description VARCHAR(4096) DEFAULT ''",
+
+	// 30: Time an uploaded object was first found unavailable on Sia. NULL
+	// while it's available.
+	"ALTER TABLE objects ADD COLUMN unavailable_since BIGINT",
+
+	// 31: Redo migration 3 for MySQL databases it left without Sia paths.
+	// Every other dialect already has a slash in each one.
+	"UPDATE objects SET sia_path = bucket || '/' || name WHERE sia_path NOT LIKE '%/%'",
+}
+
+// Replacements for schemaMigrations entries that can't be written the same
+// way in every dialect, by dialect and then migration number
+var dialectMigrations = map[string]map[int]string{
+	// || is logical OR in MySQL
+	"mysql": {
+		3: "UPDATE objects SET sia_path = CONCAT(bucket, '/', name)",
+		31: "UPDATE objects SET sia_path = CONCAT(bucket, '/', name) WHERE sia_path IS NULL OR sia_path NOT LIKE '%/%'",
+	},
+}
+
+// Returns a Sqlite URI opening the database file read-only. The path is
+// escaped, so names containing "?" or "#" aren't taken for the URI's query or
+// fragment.
+func readOnlyDSN(file string) string {
+	path := filepath.ToSlash(abs(file))
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // e.g. C:/bridge.db
+	}
+	u := url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}
+	return u.String()
+}
+
+// Returns whether a Sqlite database name refers to an in-memory database
+func isMemoryDatabase(name string) bool {
+	return name == "" || name == ":memory:" || strings.HasPrefix(name, "file::memory:") || strings.Contains(name, "mode=memory")
+}
+
+// Brings the database schema up to date by applying any migrations it hasn't
+// had yet, in the dialect provided. Each migration is applied in its own
+// transaction. If backup isn't nil, it's called first when there are
+// migrations to apply.
+func migrateDatabase(dialect string, backup func() error) error {
+	_, err := g_db.Exec("CREATE TABLE IF NOT EXISTS schema_version(version INTEGER)")
+	if err != nil {
+		return err
+	}
+
+	version, err := schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if backup != nil && version < len(schemaMigrations) {
+		err = backup()
+		if err != nil {
+			return fmt.Errorf("backing up database: %w", err)
+		}
+	}
+
+	for version < len(schemaMigrations) {
+		tx, err := g_db.Begin()
+		if err != nil {
+			return err
+		}
+
+		stmt := schemaMigrations[version]
+		if replacement, ok := dialectMigrations[dialect][version+1]; ok {
+			stmt = replacement
+		}
+
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+
+		version += 1
+		_, err = tx.Exec("UPDATE schema_version SET version=?", version)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the schema version of the database, initializing it to 0 if the
+// database has never been migrated
+func schemaVersion() (version int, e error) {
+	err := g_db.QueryRow("SELECT version FROM schema_version").Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = g_db.Exec("INSERT INTO schema_version(version) values(0)")
+		return 0, err
+	case err != nil:
+		return 0, err
+	default:
+		return version, nil
+	}
+}
+
+// Returns an error unless the database has had every migration applied. Used
+// instead of migrateDatabase when the database is opened read-only.
+func checkSchemaCurrent() error {
+	var version int
+	err := g_db.QueryRow("SELECT version FROM schema_version").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("checkSchemaCurrent: %w", err)
+	}
+	if version != len(schemaMigrations) {
+		return fmt.Errorf("checkSchemaCurrent: database schema is version %d, expected %d; open it read-write to migrate it", version, len(schemaMigrations))
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDialectMigrations(t *testing.T) {
+	for dialect, migrations := range dialectMigrations {
+		if _, ok := baseSchemas[dialect]; !ok {
+			t.Errorf("migrations replaced for unknown dialect %s", dialect)
+		}
+		for number := range migrations {
+			if number < 1 || number > len(schemaMigrations) {
+				t.Errorf("%s replaces migration %d, which doesn't exist", dialect, number)
+			}
+		}
+	}
+
+	// || concatenates strings everywhere but MySQL
+	for i, stmt := range schemaMigrations {
+		if _, ok := dialectMigrations["mysql"][i+1]; strings.Contains(stmt, "||") && !ok {
+			t.Errorf("migration %d uses || without a MySQL replacement", i+1)
+		}
+	}
+}
+
+func TestReadOnlyDSN(t *testing.T) {
+	dsn := readOnlyDSN("/var/lib/bridge/odd?name#1.db")
+	want := "file:///var/lib/bridge/odd%3Fname%231.db?mode=ro"
+	if dsn != want {
+		t.Errorf("got %s, want %s", dsn, want)
+	}
+}
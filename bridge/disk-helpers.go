@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package bridge
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Returns the number of bytes available to unprivileged users on the
+// filesystem holding path, or -1 if it can't be determined.
+func freeDiskSpace(path string) int64 {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return -1
+	}
+	return int64(st.Bavail) * int64(st.Bsize)
+}
+
+// Returns ErrCacheReadOnly or ErrCacheFull in place of a filesystem error
+// caused by the cache filesystem being read-only or full. Other errors are
+// returned unchanged.
+func cacheError(err error) error {
+	switch {
+	case errors.Is(err, syscall.EROFS):
+		return ErrCacheReadOnly
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return ErrCacheFull
+	default:
+		return err
+	}
+}
@@ -0,0 +1,32 @@
+package bridge
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows error codes for read-only and full filesystems
+const (
+	errorWriteProtect = syscall.Errno(19)
+	errorHandleDiskFull = syscall.Errno(39)
+	errorDiskFull = syscall.Errno(112)
+)
+
+// Free space isn't checked on Windows, so always report it as unknown.
+func freeDiskSpace(path string) int64 {
+	return -1
+}
+
+// Returns ErrCacheReadOnly or ErrCacheFull in place of a filesystem error
+// caused by the cache filesystem being read-only or full. Other errors are
+// returned unchanged.
+func cacheError(err error) error {
+	switch {
+	case errors.Is(err, errorWriteProtect):
+		return ErrCacheReadOnly
+	case errors.Is(err, errorDiskFull), errors.Is(err, errorHandleDiskFull):
+		return ErrCacheFull
+	default:
+		return err
+	}
+}
@@ -2,18 +2,133 @@ package bridge
 
 import (
 	"io"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
 	"os"
 	"bufio"
+	"time"
+	"compress/gzip"
+	"crypto/cipher"
 )
 
-func copyFile(in io.Reader, dst string) (err error) {
+// rateLimitedReader limits how fast data can be read from the underlying
+// reader to a fixed number of bytes per second.
+type rateLimitedReader struct {
+	r io.Reader
+	rate int64
+	start time.Time
+	total int64
+}
+
+func newRateLimitedReader(r io.Reader, rate int64) *rateLimitedReader {
+	return &rateLimitedReader{r: r, rate: rate, start: time.Now()}
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	// Keep individual reads small so the rate stays smooth
+	if int64(len(p)) > l.rate {
+		p = p[:l.rate]
+	}
+
+	n, err := l.r.Read(p)
+	l.total += int64(n)
+
+	// Sleep until reading this much data is within the rate
+	expected := time.Duration(float64(l.total) / float64(l.rate) * float64(time.Second))
+	if wait := expected - time.Since(l.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// How many bytes spaceCheckReader reads between free space checks
+const spaceCheckInterval = 1 << 20
+
+// spaceCheckReader wraps a reader of unknown length that is being copied into
+// the cache, and fails the copy if the cache filesystem is about to fill up.
+type spaceCheckReader struct {
+	r io.Reader
+	dir string
+	unchecked int64
+}
+
+func (s *spaceCheckReader) Read(p []byte) (int, error) {
+	if s.unchecked >= spaceCheckInterval {
+		free := freeDiskSpace(s.dir)
+		if free >= 0 && free < spaceCheckInterval {
+			return 0, ErrInsufficientCacheSpace
+		}
+		s.unchecked = 0
+	}
+
+	n, err := s.r.Read(p)
+	s.unchecked += int64(n)
+	return n, err
+}
+
+// filesReader reads a series of files one after another, like io.MultiReader,
+// but opens each file only when it's reached and closes it once it's read.
+type filesReader struct {
+	paths []string
+	f *os.File
+}
+
+func (r *filesReader) Read(p []byte) (int, error) {
+	for {
+		if r.f == nil {
+			if len(r.paths) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(r.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			r.f = f
+			r.paths = r.paths[1:]
+		}
+
+		n, err := r.f.Read(p)
+		if err == io.EOF {
+			r.f.Close()
+			r.f = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
 
+// Closes the file being read, if reading stopped partway through
+func (r *filesReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// Writes the contents of the reader to dst, unless dst already exists.
+// Errors caused by a read-only or full filesystem are returned as
+// ErrCacheReadOnly or ErrCacheFull.
+func copyFile(in io.Reader, dst string) error {
     // Does file already exist?
     if _, err := os.Stat(dst); err == nil {
         return nil
     }
 
-    err = nil
+    return writeFile(in, dst)
+}
+
+// Writes the contents of the reader to dst, replacing anything already there.
+// Errors are mapped like copyFile's.
+func writeFile(in io.Reader, dst string) (err error) {
+    defer func() {
+        err = cacheError(err)
+    }()
 
     out, err := os.Create(dst)
     if err != nil {
@@ -38,6 +153,179 @@ func copyFile(in io.Reader, dst string) (err error) {
     return
 }
 
+// Writes the contents of the reader to dst, gzip compressing them if compress
+// is set and then encrypting them if aead isn't nil. Returns the number of
+// bytes read. Errors caused by a read-only or full filesystem are returned as
+// ErrCacheReadOnly or ErrCacheFull.
+func encodeFile(in io.Reader, dst string, compress bool, aead cipher.AEAD, nonce []byte) (written int64, err error) {
+	defer func() {
+		err = cacheError(err)
+	}()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		cerr := out.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	var w io.Writer = out
+	var closers []io.Closer
+	if aead != nil {
+		ew := &encryptWriter{w: w, aead: aead, nonce: nonce}
+		w = ew
+		closers = append(closers, ew)
+	}
+	if compress {
+		zw := gzip.NewWriter(w)
+		w = zw
+		closers = append(closers, zw)
+	}
+
+	written, err = io.Copy(w, in)
+	if err != nil {
+		return written, err
+	}
+
+	// Flush the outermost writer first, since it writes into the others
+	for i := len(closers) - 1; i >= 0; i-- {
+		err = closers[i].Close()
+		if err != nil {
+			return written, err
+		}
+	}
+
+	err = out.Sync()
+	return
+}
+
+// Reads an object's decoded data, closing the underlying cache file on Close
+type decodedReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (d *decodedReader) Close() error {
+	return d.file.Close()
+}
+
+// Reads at most a fixed number of bytes, closing the underlying reader on Close
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func newLimitedReadCloser(r io.ReadCloser, n int64) *limitedReadCloser {
+	return &limitedReadCloser{Reader: io.LimitReader(r, n), Closer: r}
+}
+
+// A temporary file that's removed when it's closed
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name())
+	return err
+}
+
+// Copies the reader to a new temporary file in dir, positioned at the start
+func newTempFile(in io.Reader, dir string) (*tempFile, error) {
+	file, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	tmp := &tempFile{file}
+
+	_, err = io.Copy(tmp, in)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// Creates a new empty file in dir for staging data before it's renamed into
+// the cache, and returns its absolute path. Each caller gets its own file, so
+// concurrent uploads and downloads of the same object can't share one.
+func newStagingFile(dir string) (string, error) {
+	file, err := ioutil.TempFile(abs(dir), ".tmp-")
+	if err != nil {
+		return "", cacheError(err)
+	}
+	file.Close()
+	return file.Name(), nil
+}
+
+// Returns a new random 128-bit identifier, hex encoded
+func newRandomID() (string, error) {
+	id := make([]byte, 16)
+	_, err := io.ReadFull(rand.Reader, id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Moves a file, falling back to copying and removing the original when the
+// destination is on a different filesystem
+func moveFile(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if cerr := cacheError(err); cerr != err {
+		return cerr
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	err = copyFile(in, dst)
+	in.Close()
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// Returns the set of names of the files in a directory. A missing directory
+// has no files.
+func readDirNames(dir string) (map[string]bool, error) {
+	names := make(map[string]bool)
+
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range list {
+		names[name] = true
+	}
+	return names, nil
+}
+
 func readLines(path string) ([]string, error) {
 	var lines []string
 
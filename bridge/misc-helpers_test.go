@@ -0,0 +1,40 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilesReaderOpensOneFileAtATime(t *testing.T) {
+	dir := t.TempDir()
+	r := &filesReader{}
+	for i, data := range []string{"hello ", "", "world"} {
+		path := filepath.Join(dir, string(rune('a'+i)))
+		err := ioutil.WriteFile(path, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.paths = append(r.paths, path)
+	}
+
+	buf := make([]byte, 3)
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != "hel" {
+		t.Fatalf("first read got %q, %v", buf[:n], err)
+	}
+	if r.f == nil || len(r.paths) != 2 {
+		t.Errorf("%d files left to open after reading from the first", len(r.paths))
+	}
+
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "lo world" {
+		t.Errorf("got %q, want %q", rest, "lo world")
+	}
+	if r.f != nil {
+		t.Error("last file still open")
+	}
+}
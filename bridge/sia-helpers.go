@@ -1,11 +1,16 @@
 package bridge
 
 import (
+	"crypto/tls"
 	"errors"
 	"encoding/json"
+	"io/ioutil"
+	"io"
 	"net/http"
 	"path/filepath"
 	"net"
+	"net/url"
+	"strings"
 	"github.com/bgentry/speakeasy"
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/api"
@@ -14,6 +19,9 @@ import (
 // User-supplied password, cached.
 var apiPassword string
 
+// HTTP client used for siad API calls.
+var siadClient = http.DefaultClient
+
 // bySiaPath implements sort.Interface for [] modules.FileInfo based on the
 // SiaPath field.
 type bySiaPath []modules.FileInfo
@@ -33,32 +41,115 @@ func abs(path string) string {
 	return abspath
 }
 
+// escapeSiaPath escapes each element of a siapath so it can be embedded in
+// the path of an API call.
+func escapeSiaPath(siapath string) string {
+	elems := strings.Split(siapath, "/")
+	for i, elem := range elems {
+		elems[i] = url.PathEscape(elem)
+	}
+	return strings.Join(elems, "/")
+}
+
 // non2xx returns true for non-success HTTP status codes.
 func non2xx(code int) bool {
 	return code < 200 || code > 299
 }
 
-// decodeError returns the api.Error from a API response. This method should
-// only be called if the response's status code is non-2xx. The error returned
-// may not be of type api.Error in the event of an error unmarshalling the
-// JSON.
-func decodeError(resp *http.Response) error {
+// SiadError is returned when siad responds to an API call with an error.
+type SiadError struct {
+	Call       string // API call that failed
+	StatusCode int    // HTTP status code of the response
+	Message    string // Error message reported by siad
+}
+
+func (e *SiadError) Error() string {
+	return "siad " + e.Call + ": " + e.Message
+}
+
+// decodeError returns a *SiadError describing a API response. This method
+// should only be called if the response's status code is non-2xx. The
+// message is taken from the standard Sia API error envelope, falling back to
+// the raw response body or status if the body isn't in that format.
+func decodeError(resp *http.Response, call string) error {
+	siadErr := &SiadError{Call: call, StatusCode: resp.StatusCode}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		siadErr.Message = resp.Status
+		return siadErr
+	}
+
 	var apiErr api.Error
-	err := json.NewDecoder(resp.Body).Decode(&apiErr)
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+		siadErr.Message = apiErr.Message
+	} else if msg := strings.TrimSpace(string(body)); msg != "" {
+		siadErr.Message = msg
+	} else {
+		siadErr.Message = resp.Status
+	}
+	return siadErr
+}
+
+// isFileExistsError returns whether err is siad refusing to upload a file
+// because one already exists at the siapath.
+func isFileExistsError(err error) bool {
+	var siadErr *SiadError
+	return errors.As(err, &siadErr) && strings.Contains(siadErr.Message, "already exists")
+}
+
+// siadURL returns the base URL of the siad API at addr. An address that
+// includes a scheme, such as "https://siad.example.com:9980", is used as is.
+// An address with no host, such as ":9980", refers to localhost.
+func siadURL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return strings.TrimSuffix(addr, "/")
+	}
+	if host, port, _ := net.SplitHostPort(addr); host == "" {
+		addr = net.JoinHostPort("localhost", port)
+	}
+	return "http://" + addr
+}
+
+// setSiadTLSConfig makes siad API calls over https use the TLS configuration
+// provided, e.g. to trust a custom CA.
+func setSiadTLSConfig(config *tls.Config) {
+	siadClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: config,
+		},
+	}
+}
+
+// siadRequest makes a GET or POST request to siad with the User-Agent siad
+// requires, authenticating with password if it's not empty. vals is the
+// form-encoded body of a POST.
+func siadRequest(method, url, vals, password string) (*http.Response, error) {
+	var body io.Reader
+	if method == "POST" {
+		body = strings.NewReader(vals)
+	}
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Sia-Agent")
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
-	return apiErr
+	if password != "" {
+		req.SetBasicAuth("", password)
+	}
+	return siadClient.Do(req)
 }
 
 // apiGet wraps a GET request with a status code check, such that if the GET does
 // not return 2xx, the error will be read and returned. The response body is
 // not closed.
 func apiGet(addr, call string) (*http.Response, error) {
-	if host, port, _ := net.SplitHostPort(addr); host == "" {
-		addr = net.JoinHostPort("localhost", port)
-	}
-	resp, err := api.HttpGET("http://" + addr + call)
+	base := siadURL(addr)
+	resp, err := siadRequest("GET", base+call, "", "")
 	if err != nil {
 		return nil, errors.New("no response from daemon")
 	}
@@ -74,7 +165,7 @@ func apiGet(addr, call string) (*http.Response, error) {
 				return nil, err
 			}
 		}
-		resp, err = api.HttpGETAuthenticated("http://"+addr+call, apiPassword)
+		resp, err = siadRequest("GET", base+call, "", apiPassword)
 		if err != nil {
 			return nil, errors.New("no response from daemon - authentication failed")
 		}
@@ -84,7 +175,7 @@ func apiGet(addr, call string) (*http.Response, error) {
 		return nil, errors.New("API call not recognized: " + call)
 	}
 	if non2xx(resp.StatusCode) {
-		err := decodeError(resp)
+		err := decodeError(resp, call)
 		resp.Body.Close()
 		return nil, err
 	}
@@ -95,12 +186,8 @@ func apiGet(addr, call string) (*http.Response, error) {
 // does not return 2xx, the error will be read and returned. The response body
 // is not closed.
 func apiPost(addr, call, vals string) (*http.Response, error) {
-	
-	if host, port, _ := net.SplitHostPort(addr); host == "" {
-		addr = net.JoinHostPort("localhost", port)
-	}
-
-	resp, err := api.HttpPOST("http://"+addr+call, vals)
+	base := siadURL(addr)
+	resp, err := siadRequest("POST", base+call, vals, "")
 	if err != nil {
 		return nil, errors.New("no response from daemon")
 	}
@@ -112,7 +199,7 @@ func apiPost(addr, call, vals string) (*http.Response, error) {
 		if err != nil {
 			return nil, err
 		}
-		resp, err = api.HttpPOSTAuthenticated("http://"+addr+call, vals, password)
+		resp, err = siadRequest("POST", base+call, vals, password)
 		if err != nil {
 			return nil, errors.New("no response from daemon - authentication failed")
 		}
@@ -122,7 +209,7 @@ func apiPost(addr, call, vals string) (*http.Response, error) {
 		return nil, errors.New("API call not recognized: " + call)
 	}
 	if non2xx(resp.StatusCode) {
-		err := decodeError(resp)
+		err := decodeError(resp, call)
 		resp.Body.Close()
 		return nil, err
 	}
@@ -160,6 +247,52 @@ func getAPI(addr string, call string, obj interface{}) error {
 	return nil
 }
 
+// renterFileMap returns the renter's files keyed by siapath, so many objects
+// can be looked up without scanning the whole list for each.
+func renterFileMap(addr string) (map[string]modules.FileInfo, error) {
+	var rf api.RenterFiles
+	err := getAPI(addr, "/renter/files", &rf)
+	if err != nil {
+		return nil, err
+	}
+	return filesBySiaPath(rf.Files), nil
+}
+
+// filesBySiaPath returns the files provided keyed by siapath.
+func filesBySiaPath(list []modules.FileInfo) map[string]modules.FileInfo {
+	files := make(map[string]modules.FileInfo, len(list))
+	for _, file := range list {
+		files[file.SiaPath] = file
+	}
+	return files
+}
+
+// isUnknownPathError returns whether err is siad reporting that it has no
+// file at a siapath.
+func isUnknownPathError(err error) bool {
+	var siadErr *SiadError
+	return errors.As(err, &siadErr) && strings.Contains(siadErr.Message, "no file known")
+}
+
+// renterFilesFor queries siad for each of the siapaths separately and returns
+// the files it has, keyed by siapath. For a few siapaths this is much cheaper
+// than fetching every renter file.
+func renterFilesFor(addr string, siapaths []string) (map[string]modules.FileInfo, error) {
+	files := make(map[string]modules.FileInfo, len(siapaths))
+	for _, siapath := range siapaths {
+		var rf api.RenterFile
+		err := getAPI(addr, "/renter/file/"+escapeSiaPath(siapath), &rf)
+		if isUnknownPathError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[siapath] = rf.File
+	}
+	return files, nil
+}
+
 // get makes an API call and discards the response. An error is returned if the
 // response status is not 2xx.
 func get(addr, call string) error {
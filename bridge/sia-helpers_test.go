@@ -0,0 +1,82 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+func TestDecodeError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"json envelope", `{"message":"no file known by that path"}`, "no file known by that path"},
+		{"plain text", "renter is not ready\n", "renter is not ready"},
+		{"empty body", "", "500 Internal Server Error"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			err := get(server.URL, "/renter/files")
+			var siadErr *SiadError
+			if !errors.As(err, &siadErr) {
+				t.Fatalf("got %v, want a *SiadError", err)
+			}
+			if siadErr.Message != test.want {
+				t.Errorf("got message %q, want %q", siadErr.Message, test.want)
+			}
+			if siadErr.StatusCode != http.StatusInternalServerError || siadErr.Call != "/renter/files" {
+				t.Errorf("got status %d for call %s", siadErr.StatusCode, siadErr.Call)
+			}
+		})
+	}
+}
+
+// Compares finding each uploading object's renter file by scanning the whole
+// list, as checkSiaUploads used to, with looking it up by siapath
+func BenchmarkMatchRenterFiles(b *testing.B) {
+	const numFiles = 10000
+	const numUploading = 1000
+
+	list := make([]modules.FileInfo, numFiles)
+	for i := range list {
+		list[i] = modules.FileInfo{SiaPath: fmt.Sprintf("bucket/object-%d", i)}
+	}
+	uploading := make([]string, numUploading)
+	for i := range uploading {
+		uploading[i] = list[i*numFiles/numUploading].SiaPath
+	}
+
+	b.Run("scan", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, siapath := range uploading {
+				for _, file := range list {
+					if file.SiaPath == siapath {
+						break
+					}
+				}
+			}
+		}
+	})
+
+	b.Run("map", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			files := filesBySiaPath(list)
+			for _, siapath := range uploading {
+				_ = files[siapath]
+			}
+		}
+	})
+}
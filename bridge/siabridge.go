@@ -5,35 +5,344 @@
 package bridge
 
 import (
+	"context"
 	"fmt"
 	"time"
 	"os"
+	"os/signal"
+	"syscall"
 	"io"
+	"io/ioutil"
 	"path/filepath"
 	"errors"
 	"database/sql"
+	"encoding/json"
+	"encoding/csv"
+	"strings"
+	"net/url"
+	"path"
+	"sync"
+	"sync/atomic"
+	"strconv"
+	"crypto/tls"
+	"compress/gzip"
+	"crypto/cipher"
+	"encoding/hex"
+	"math/big"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/NebulousLabs/Sia/api"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
 )
 
-// How many seconds to delay between cache/db management operations
+// Default number of seconds to delay between cache/db management operations
 const MANAGER_DELAY_SEC = 30
 
+// Cache purge setting given to objects recreated by RebuildFromSia
+const REBUILD_PURGE_AFTER_SEC = 24*60*60
+
+// How many seconds to wait between polls of siad's upload progress for
+// PutObjectFromReaderStream
+const UPLOAD_POLL_SEC = 5
+
+// Up to this many pending uploads are checked on one at a time, rather than
+// by fetching siad's entire list of renter files
+const FILE_QUERY_MAX_UPLOADS = 20
+
+// Seconds to wait before the first retry of a failed Sia download. The wait
+// doubles with each further retry.
+const DOWNLOAD_RETRY_DELAY_SEC = 2
+
+// Directory within CacheDir holding the parts of multipart uploads
+const MULTIPART_DIR = ".multipart"
+
 // Global ticker for cache management
 var g_cache_ticker *time.Ticker
 
+// Global ticker for upload checks run separately from cache management.
+// Nil unless UploadCheckInterval is set.
+var g_upload_ticker *time.Ticker
+
 // Global database object
-var g_db *sql.DB
+var g_db *database
+
+// Nonzero while the cache management process is paused
+var g_manager_paused int32
+
+// Held while the cache management process runs, so Stop can wait for it
+var g_manager_mutex sync.Mutex
+
+// Held for reading while objects' data is written into CacheDir or handed to
+// siad from it, and for writing by RelocateCache, so nothing is written to or
+// uploaded from the old directory while it's being moved
+var g_cache_mutex sync.RWMutex
+
+// Guards the bridge's CacheDir after Start, since RelocateCache changes it
+var g_cache_dir_mutex sync.Mutex
+
+// Closed by Stop, so goroutines watching uploads know to give up
+var g_stop chan struct{}
+
+// Time uploaded objects were last checked for availability on Sia. Guarded by
+// g_manager_mutex.
+var g_last_availability_check time.Time
+
+// Recent Sia download failures by siapath, remembered for DownloadFailureTTL
+var g_failed_downloads = make(map[string]downloadFailure)
+var g_failed_mutex sync.Mutex
+
+// A failed Sia download
+type downloadFailure struct {
+	err error
+	at time.Time
+}
+
+// Columns of the objects table read by scanObject, in scan order
+const objectColumns = "bucket,name,size,queued,uploaded,purge_after,cached_fetches,sia_fetches,last_fetch,cache_dir,sia_path,retain_until,compressed,stored_size,nonce,modified,pinned,id,expires_at,acl,description,unavailable_since"
+
+// Implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Implemented by both the database and transactions
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Implemented by both the database and transactions
+type queryer interface {
+	execer
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Returned when using a bucket that doesn't exist
+var ErrBucketNotFound = errors.New("Bucket does not exist")
+
+// Returned when an upload exceeds MaxObjectBytes
+var ErrObjectTooLarge = errors.New("Object exceeds maximum allowed size")
+
+// Returned when the data given for an upload doesn't have the size declared
+// for it
+var ErrSizeMismatch = errors.New("Object data doesn't match the declared size")
+
+// Returned when VerifyCacheSize is set and the cached copy of an object that
+// hasn't finished uploading has the wrong size
+var ErrCacheSizeMismatch = errors.New("Cached object's size doesn't match its record")
+
+// Returned when the cache directory's filesystem is read-only
+var ErrCacheReadOnly = errors.New("Cache filesystem is read-only")
+
+// Returned when the cache directory's filesystem runs out of space while
+// writing an object
+var ErrCacheFull = errors.New("Cache filesystem is full")
+
+// Returned when deleting an object that is retained until a future time
+var ErrObjectLocked = errors.New("Object is locked by its retention period")
+
+// Returned when creating an object with the same name as one already in the bucket
+var ErrObjectExists = errors.New("Object with same name already exists in bucket")
+
+// Returned when changing an object that siad is still uploading
+var ErrUploadInProgress = errors.New("Object is still uploading to Sia")
+
+// Returned when a Sia download takes longer than DownloadTimeout
+var ErrDownloadTimeout = errors.New("Timed out downloading object from Sia")
+
+// Returned when waiting for an object to finish uploading to Sia takes longer
+// than the timeout. The object is stored and its upload carries on.
+var ErrUploadTimeout = errors.New("Timed out waiting for object to upload to Sia")
+
+// Returned when the bridge is stopped while waiting for an object to finish
+// uploading to Sia. The object is stored and its upload carries on.
+var ErrBridgeStopped = errors.New("Sia Bridge was stopped")
+
+// Returned when VerifyDownloads is set and data downloaded from Sia doesn't
+// match the object
+var ErrDownloadCorrupt = errors.New("Object downloaded from Sia is corrupt")
+
+// Returned when the cache filesystem doesn't have room for an upload
+var ErrInsufficientCacheSpace = errors.New("Insufficient free space in cache directory")
+
+// Returned when encrypting or decrypting an object without an EncryptionKey
+var ErrNoEncryptionKey = errors.New("No encryption key configured")
+
+// Returned when a Sia upload or download fails because siad's wallet is locked
+var ErrWalletLocked = errors.New("Sia wallet is locked")
+
+// Returned when using a multipart upload ID that doesn't exist
+var ErrNoSuchUpload = errors.New("Multipart upload does not exist")
+
+// Returned when fetching an object that isn't cached and is still uploading to Sia
+var ErrObjectNotYetAvailable = errors.New("Object has not finished uploading to Sia")
+
+// Returned by CheckObjectAvailable for an object that is neither cached nor
+// known to siad, so it can never be fetched
+var ErrObjectLost = errors.New("Object is neither cached nor stored on Sia")
+
+// Returned when uploading an object whose siapath siad already has a file at,
+// for example one left behind when the object's record was lost.
+// RebuildFromSia can recreate the record from the existing file.
+var ErrSiaFileExists = errors.New("A file already exists on Sia at the object's siapath")
+
+// Returned by VerifyBucket for an uploaded object whose file siad no longer
+// reports as available, e.g. because too few of its hosts are online
+var ErrObjectUnavailable = errors.New("Object's file on Sia is not available")
+
+// Returned by RestoreToSia when the object's data isn't in the cache
+var ErrObjectNotCached = errors.New("Object is not in the cache")
+
+// Returned when an upload would take a bucket over its quota
+var ErrQuotaExceeded = errors.New("Bucket quota exceeded")
+
+// Returned when an upload would take a bucket over its maximum number of objects
+var ErrObjectQuotaExceeded = errors.New("Bucket object quota exceeded")
+
+// Returned when setting an object's ACL to a value other than ACLPrivate or
+// ACLPublicRead
+var ErrInvalidACL = errors.New("Invalid object ACL")
+
+// Returned when a bucket or object name would not be a path element of its
+// own in the cache and on Sia, such as "" or "..", or when a bucket would be
+// named MULTIPART_DIR
+var ErrInvalidName = errors.New("Invalid bucket or object name")
+
+// Returned when changing anything while the bridge's ReadOnly field is set
+var ErrReadOnly = errors.New("Bridge is read-only")
+
+// Returned when ExportObjectList is given a format it doesn't support
+var ErrUnknownListFormat = errors.New("Unknown object list format")
 
 type SiaBridge struct {
-	SiadAddress string 	// Address of siad daemon API. (e.g., "127.0.0.1:9980")
+	SiadAddress string 	// Address of siad daemon API. (e.g., "127.0.0.1:9980", or "https://siad.example.com:9980")
+	TLSConfig *tls.Config // TLS settings for an https SiadAddress, e.g. a custom CA. Uses the system's if nil.
 	CacheDir string 	// Cache directory for downloads
 	DbFile string 		// Name and path of Sqlite database file
+	DbDriver string 	// Optional database/sql driver name. Defaults to "sqlite3".
+	DbDSN string 		// Optional data source name for DbDriver. Defaults to DbFile.
+	DbMaxOpenConns int 	// Maximum open database connections. No limit if value is 0.
+	DbMaxIdleConns int 	// Maximum idle database connections. Uses database/sql's default if value is 0.
+	DbConnMaxLifetime time.Duration // Close database connections after this long. Never if value is 0.
+	ReadOnly bool 		// Serve reads only. Changes return ErrReadOnly, fetches aren't counted and the
+	              		// manager doesn't run. A Sqlite DbFile is opened with mode=ro.
+	BackupBeforeMigrate bool // Copy DbFile to DbFile.bak-<timestamp> before applying schema migrations.
+	                         // Only for a Sqlite database file named by DbFile.
+	MaxObjectBytes int64 // Maximum size of an uploaded object in bytes. No limit if value is 0.
+	HotCacheDir string  // Optional faster cache directory for frequently fetched objects
+	PromoteFetches int64 // Move objects to HotCacheDir after this many cached fetches.
+	                     // Never promote if value is 0.
+	DownloadRateLimit int64 // Maximum bytes per second to download from Sia. No limit if value is 0.
+	UploadRateLimit int64 // Maximum bytes per second to accept into the cache for upload.
+	                      // No limit if value is 0.
+	UploadAvailableThreshold float64 // Also consider an upload complete once siad reports this
+	                                 // upload progress percentage (e.g., 100). Uses only siad's
+	                                 // Available flag if value is 0.
+	VerifyCacheSize bool // Check that a cached object's file has the size recorded for it before serving it
+	VerifyDownloads bool // Check the size of data downloaded from Sia, and that encrypted or
+	                     // compressed data decodes, before moving it into the cache
+	ServeStaleOnSiaError bool // If a Sia download fails, serve any copy of the object left in
+	                          // another cache directory instead of failing
+	DownloadTimeout time.Duration // Give up on a Sia download after this long. No limit if value is 0.
+	UploadTimeout time.Duration // Stop reporting the progress of an upload started by
+	                            // PutObjectFromReaderStream after this long. No limit if value is 0.
+	DownloadRetries int // Retry a failed Sia download this many times, waiting longer before each
+	                    // retry. Timeouts aren't retried. Never retry if value is 0.
+	EncryptionKey []byte // AES key (16, 24 or 32 bytes) for objects uploaded with PutOptions.Encrypt.
+	                     // Objects can't be read back without the same key.
+	WalletPassword string // Optional Sia wallet password. If set, Start unlocks the wallet if it's locked.
+	DownloadFailureTTL time.Duration // After a Sia download fails, fail further downloads of the object
+	                                 // with the same error for this long. Always retry if value is 0.
+	ManagerInterval time.Duration // How often to manage the database and cache.
+	                              // Defaults to MANAGER_DELAY_SEC seconds.
+	UploadCheckInterval time.Duration // If set, check for completed uploads this often instead of
+	                                  // every ManagerInterval
+	AutoCreateBucket bool // Create the bucket when putting an object into a bucket that doesn't exist
+	CancelUploadOnDelete bool // Let DeleteObject delete an object still uploading by cancelling its
+	                          // upload first, instead of returning ErrUploadInProgress
+	EnableAuditLog bool // Record every put, delete, rename and fetch of an object, for ListEvents
+	OnManagerError func(err error) // If set, called with each error the bridge hits in the background or
+	                               // recovers from, such as ErrCacheReadOnly or ErrCacheFull from the
+	                               // cache management process, after it's logged
+	WarmOnStart int 	// When started, download this many of the most fetched objects into the cache
+	                	// in the background, if they're not already cached
+	PurgePolicy PurgePolicy // Adjusts how long objects stay in the cache based on how often they're fetched
+	ShouldPurge func(obj ObjectInfo, cacheSizeUsed int64) bool // If set, decides which uploaded objects to purge
+	                                                           // from the cache instead of PurgeAfter and PurgePolicy
+	StatsInterval time.Duration // Record object count and sizes this often, for GetStatsHistory.
+	                            // Disabled if value is 0.
+	StatsRetention time.Duration // Delete recorded stats older than this. Kept forever if value is 0.
+	AvailabilityCheckInterval time.Duration // Check this often that uploaded objects are still available
+	                                        // on Sia. Never checked if value is 0.
+	OnObjectUnavailable func(obj ObjectInfo) // If set, called when an uploaded object is first found
+	                                         // unavailable on Sia
+}
+
+// Adjusts each object's PurgeAfter window based on its total number of
+// fetches. The zero value leaves every object's PurgeAfter as is.
+type PurgePolicy struct {
+	UnfetchedPurgeAfter int64 	// Purge objects never fetched after this many seconds, if sooner than
+	                          	// their PurgeAfter. Disabled if value is 0.
+	FrequentFetches int64 		// Objects fetched at least this many times are frequently fetched.
+	                      		// Disabled if value is 0.
+	FrequentPurgeAfter int64 	// Keep frequently fetched objects for this many seconds, if longer
+	                         	// than their PurgeAfter
+	ScanBatchSize int 			// Pause the cache scan after checking this many objects, to spread out
+	                  			// its disk I/O. Disabled if value is 0.
+	ScanPause time.Duration 	// How long to pause the cache scan after each batch
 }
 
 type BucketInfo struct {
 	Name string 		// Name of bucket
 	Created time.Time   // Time of bucket creation
+	QuotaBytes int64 	// Maximum total size in bytes of the bucket's objects. No limit if value is 0.
+	MaxObjects int64 	// Maximum number of objects in the bucket. No limit if value is 0.
+}
+
+// A bucket's info along with its usage, as returned by ListBucketsWithCounts
+type BucketSummary struct {
+	BucketInfo
+	Objects int64 		// Number of objects in the bucket
+	Bytes int64 		// Total size of the bucket's objects in bytes
+}
+
+// Usage of a bucket, as returned by GetBucketStats
+type BucketStats struct {
+	Name string 		// Name of bucket
+	Objects int64 		// Number of objects in the bucket
+	Bytes int64 		// Total size of the bucket's objects in bytes
+	QuotaBytes int64 	// Maximum total size in bytes of the bucket's objects. No limit if value is 0.
+	MaxObjects int64 	// Maximum number of objects in the bucket. No limit if value is 0.
+}
+
+// Sizes recorded by the manager every StatsInterval, as returned by
+// GetStatsHistory
+type StatsSample struct {
+	Time time.Time 		// Time the sample was taken
+	Objects int64 		// Number of objects in all buckets
+	Bytes int64 		// Total size of all objects in bytes
+	CacheBytes int64 	// Total size of the objects' data in the cache directories, in bytes
+}
+
+// What ReconcileCache found and did
+type CacheReconciliation struct {
+	Removed int64 		// Number of orphaned cache files removed
+	NotCached int64 	// Number of uploaded objects whose data isn't cached, as after a purge
+	Missing []string 	// "bucket/objectName" of each object still uploading whose data is
+	                	// missing from the cache. Their uploads can never finish.
+}
+
+// Database contents written by ExportMetadata and read by ImportMetadata
+type metadataDump struct {
+	Buckets []BucketInfo
+	Objects []ObjectInfo
+}
+
+// Random access reader over an object's data, as returned by OpenObject
+type ReadSeekCloser interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
 }
 
 type ObjectInfo struct {
@@ -41,47 +350,290 @@ type ObjectInfo struct {
 	Name string 		// Name of object
 	Size int64			// Size of the object in bytes
 	Queued time.Time 	// Time object was queued for upload to Sia
-	Uploaded time.Time 	// Time object was successfully uploaded to Sia
+	Uploaded *time.Time // Time object was successfully uploaded to Sia. Nil until uploaded.
 	PurgeAfter int64    // If no downloads in this many seconds, purge from cache.
 	                    // Always in cache if value is 0.
 	CachedFetches int64	// The total number of times the object has been fetched from cache
 	SiaFetches int64 	// The total number of times the object has been fetched from Sia network
-	LastFetch time.Time // The time of the last fetch request for the object
+	LastFetch *time.Time // The time of the last fetch request for the object. Nil if never fetched.
+	CacheDir string 	// Cache directory holding the object. Empty if it's the bridge's CacheDir.
+	SiaPath string 		// Path of the object on the Sia network
+	RetainUntil *time.Time // Object can't be deleted before this time. Nil if not retained.
+	Compressed bool 	// Whether the object's data is gzip compressed in the cache and on Sia
+	StoredSize int64 	// Size of the object's data in the cache and on Sia, in bytes
+	Nonce []byte 		// Nonce the object's data was encrypted with. Nil if not encrypted.
+	Modified time.Time 	// Time the object's record was last changed
+	Pinned bool 		// Whether the object is kept in the cache until it's unpinned
+	ID string 			// Permanent identifier of the object, which doesn't change when it's renamed
+	          			// or moved to another bucket
+	ExpiresAt *time.Time // Time after which the object is deleted from the bridge and Sia. Nil if
+	                     // it never expires.
+	ACL ObjectACL 		// Who the object may be served to, for a frontend to enforce
+	Description string 	// Free-text description of the object
+	UnavailableSince *time.Time // Time the uploaded object was first found unavailable on Sia. Nil if
+	                            // it was available when last checked, or has never been checked.
+}
+
+// An object that has been deleted, as returned by ListObjectsDeletedSince
+type DeletedObject struct {
+	Bucket string 		// Name of bucket object was stored in
+	Name string 		// Name of object
+	Deleted time.Time 	// Time object was deleted
+}
+
+// An object's info along with whether its data is currently cached, as
+// returned by ListObjectsDetailed
+type ObjectDetail struct {
+	ObjectInfo
+	Cached bool 		// Whether the object's data is in the cache
+}
+
+// Kinds of events sent by PutObjectFromReaderStream
+type UploadEventType int
+
+const (
+	UploadCached UploadEventType = iota // The object's data is in the cache. Bytes is set.
+	UploadProgress 		// Siad's upload progress changed. Progress is set.
+	UploadComplete 		// The object is uploaded to Sia. Always the last event.
+	UploadFailed 		// Checking on the upload failed. Err is set. Always the last event.
+)
+
+// State of an object's upload to Sia, as returned by UploadState
+type UploadState int
+
+const (
+	UploadStateInProgress UploadState = iota // Siad is still uploading the object
+	UploadStateComplete 	// The object is uploaded to Sia
+	UploadStateFailed 		// Siad has no record of the object, so it must be uploaded again
+)
+
+// Visibility of an object, as set by SetObjectACL. The bridge doesn't enforce
+// it; it's recorded for a frontend serving objects over HTTP to check.
+type ObjectACL string
+
+const (
+	ACLPrivate ObjectACL = "private" 		// Only served to authenticated clients. The default.
+	ACLPublicRead ObjectACL = "public-read" // Served to anyone
+)
+
+// Formats ExportObjectList can write a bucket's listing in
+type ListFormat int
+
+const (
+	FormatCSV ListFormat = iota // A header row, then one row per object
+	FormatJSON 			// A JSON array of ObjectInfo
+)
+
+// Progress of an upload, as sent by PutObjectFromReaderStream
+type UploadEvent struct {
+	Type UploadEventType
+	Bytes int64 		// Number of bytes of the object's data in the cache
+	Progress float64 	// Siad's upload progress percentage
+	Err error 			// Why checking on the upload failed
+}
+
+// Kinds of operations recorded in the audit log
+type EventType string
+
+const (
+	EventPut EventType = "put"
+	EventDelete EventType = "delete"
+	EventRename EventType = "rename"
+	EventFetch EventType = "fetch"
+)
+
+// An operation on an object recorded in the audit log, as returned by ListEvents
+type ObjectEvent struct {
+	Time time.Time 		// Time of the operation
+	Type EventType 		// Kind of operation
+	Bucket string 		// Name of bucket object is stored in
+	Name string 		// Name of object
+	Detail string 		// The new bucket for a rename, "cache" or "sia" for a fetch, or "append" for
+	              		// a put by AppendToObject
+}
+
+// Options for uploading an object with PutObjectWithOptions
+type PutOptions struct {
+	PurgeAfter int64 	// If no downloads in this many seconds, purge from cache
+	RetainUntil time.Time // Refuse to delete the object before this time. Zero for no retention.
+	ExpiresAt time.Time // Delete the object, including from Sia, after this time. Zero to keep it
+	                    // until it's deleted.
+	Compress bool 		// Gzip the object's data before uploading it to Sia
+	Encrypt bool 		// Encrypt the object's data with the bridge's EncryptionKey before
+	                    // uploading it to Sia
+	ACL ObjectACL 		// Visibility of the object. ACLPrivate if empty.
+	Description string 	// Free-text description of the object
+	WaitForSia bool 	// Don't return until the object has finished uploading to Sia
+	WaitTimeout time.Duration // Give up waiting for the upload after this long. No limit if value is 0.
+}
+
+// Returns whether the object has finished uploading to Sia
+func (o ObjectInfo) IsUploaded() bool {
+	return o.Uploaded != nil
+}
+
+// Returns whether the object's retention period forbids deleting it now
+func (o ObjectInfo) isLocked() bool {
+	return o.RetainUntil != nil && time.Now().Before(*o.RetainUntil)
 }
 
 // Called to start running the SiaBridge
 func (b *SiaBridge) Start() error {
-	// Make sure cache directory exists
+	// Make sure cache directories exist
 	os.Mkdir(b.CacheDir, 0744)
+	if b.HotCacheDir != "" {
+		os.Mkdir(b.HotCacheDir, 0744)
+	}
+
+	if b.TLSConfig != nil {
+		setSiadTLSConfig(b.TLSConfig)
+	}
 
 	// Open and initialize database
 	err := b.initDatabase()
 	if err != nil {
 		return err
 	}
+	g_stop = make(chan struct{})
+
+	// Siad may have been restarted with its wallet locked
+	if b.WalletPassword != "" {
+		err = b.UnlockWallet(b.WalletPassword)
+		if err != nil {
+			g_db.Close()
+			return err
+		}
+	}
+
+	// Reduce cold-start latency without delaying startup
+	if b.WarmOnStart > 0 {
+		go func() {
+			err := b.warmCache(b.WarmOnStart)
+			if err != nil {
+				b.managerError(fmt.Errorf("warmCache: %w", err))
+			}
+		}()
+	}
+
+	// A read-only bridge can't mark uploads complete, so has nothing to manage
+	if b.ReadOnly {
+		return nil
+	}
 
 	// Start the cache management process
-	g_cache_ticker = time.NewTicker(time.Second * MANAGER_DELAY_SEC)
+	interval := b.ManagerInterval
+	if interval <= 0 {
+		interval = time.Second * MANAGER_DELAY_SEC
+	}
+	g_cache_ticker = time.NewTicker(interval)
     go func() {
         for _ = range g_cache_ticker.C {
         	b.manager()
         }
     }()
 
+	// Check for completed uploads on their own schedule, if one is set
+	if b.UploadCheckInterval > 0 {
+		g_upload_ticker = time.NewTicker(b.UploadCheckInterval)
+		go func() {
+			for _ = range g_upload_ticker.C {
+				b.uploadManager()
+			}
+		}()
+	}
+
     return nil
 }
 
 // Called to stop the SiaBridge
 func (b *SiaBridge) Stop() {
-	// Stop cache management process
-	g_cache_ticker.Stop()
+	// Stop cache management process, which a read-only bridge doesn't run
+	if g_cache_ticker != nil {
+		g_cache_ticker.Stop()
+		g_cache_ticker = nil
+	}
+	if g_upload_ticker != nil {
+		g_upload_ticker.Stop()
+		g_upload_ticker = nil
+	}
+	if g_stop != nil {
+		close(g_stop)
+		g_stop = nil
+	}
+
+	// Let a management run already in progress finish with the database
+	g_manager_mutex.Lock()
+	defer g_manager_mutex.Unlock()
 
 	// Close the database
 	g_db.Close()
 }
 
+// Blocks until the process receives SIGINT or SIGTERM or the context is
+// cancelled, then stops the bridge. For long-running services that should
+// shut down cleanly.
+func (b *SiaBridge) RunUntilSignal(ctx context.Context) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case <-signals:
+	case <-ctx.Done():
+	}
+
+	b.Stop()
+	return nil
+}
+
+// Returns the base URL of the siad API the bridge talks to
+func (b *SiaBridge) SiadEndpoint() string {
+	return siadURL(b.SiadAddress)
+}
+
+// Makes a GET request to an arbitrary siad API path, such as
+// "/renter/contracts", and decodes the JSON response into out. The response is
+// discarded if out is nil. This is an escape hatch for siad features the
+// bridge doesn't wrap; the bridge doesn't interpret the call in any way.
+func (b *SiaBridge) SiadGet(path string, out interface{}) error {
+	if out == nil {
+		return get(b.SiadAddress, path)
+	}
+	return getAPI(b.SiadAddress, path, out)
+}
+
+// Makes a POST request to an arbitrary siad API path with the form encoded
+// body provided, such as url.Values{...}.Encode(). Like SiadGet, this is an
+// escape hatch for siad features the bridge doesn't wrap.
+func (b *SiaBridge) SiadPost(path string, body string) error {
+	return post(b.SiadAddress, path, body)
+}
+
+// Unlocks siad's wallet with the password provided, so that uploads and
+// downloads can be paid for. Does nothing if the wallet is already unlocked.
+func (b *SiaBridge) UnlockWallet(password string) error {
+	unlocked, err := b.walletUnlocked()
+	if err != nil {
+		return err
+	}
+	if unlocked {
+		return nil
+	}
+
+	return post(b.SiadAddress, "/wallet/unlock", url.Values{"encryptionpassword": {password}}.Encode())
+}
+
 // Creates a new bucket for storing objectserror
 func (b *SiaBridge) CreateBucket(bucket string) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	err := checkBucketName(bucket)
+	if err != nil {
+		return err
+	}
+
 	// If bucket already exists, return success
 	exists, err := b.bucketExists(bucket)
 	if err != nil {
@@ -100,17 +652,17 @@ func (b *SiaBridge) CreateBucket(bucket string) error {
 func (b *SiaBridge) GetBucketInfo(bucket string) (bi BucketInfo, e error) {
 	// Query the database
 	var created int64
-	err := g_db.QueryRow("SELECT created FROM buckets WHERE name=?", bucket).Scan(&created)
+	err := g_db.QueryRow("SELECT created, quota_bytes, max_objects FROM buckets WHERE name=?", bucket).Scan(&created, &bi.QuotaBytes, &bi.MaxObjects)
 	switch {
 	case err == sql.ErrNoRows:
-	   return bi, errors.New("Bucket does not exist")
+	   return bi, ErrBucketNotFound
 	case err != nil:
 		// An error occured
 	    return bi, err 		
 	default:
 		// Bucket exists
 		bi.Name = bucket
-		bi.Created = time.Unix(created,0)
+		bi.Created = fromMillis(created)
 		return bi, nil
 	}
 
@@ -120,23 +672,27 @@ func (b *SiaBridge) GetBucketInfo(bucket string) (bi BucketInfo, e error) {
 
 // List all buckets
 func (b *SiaBridge) ListBuckets() (buckets []BucketInfo, e error) {
-	rows, err := g_db.Query("SELECT * FROM buckets")
+	rows, err := g_db.Query("SELECT name, created, quota_bytes, max_objects FROM buckets")
     if err != nil {
     	return buckets, err
     }
 
     var name string
     var created int64
+    var quota int64
+    var max_objects int64
 
     for rows.Next() {
-        err = rows.Scan(&name, &created)
+        err = rows.Scan(&name, &created, &quota, &max_objects)
         if err != nil {
         	return buckets, err
         }
 
         buckets = append(buckets, BucketInfo{
     		Name:		name,
-    		Created: 	time.Unix(created, 0),
+    		Created: 	fromMillis(created),
+    		QuotaBytes:	quota,
+    		MaxObjects:	max_objects,
     	})
     }
 
@@ -145,354 +701,3500 @@ func (b *SiaBridge) ListBuckets() (buckets []BucketInfo, e error) {
 	return buckets, nil
 }
 
-// Delete a bucket, as well as all contents of the bucket
-func (b *SiaBridge) DeleteBucket(bucket string) error {
-	stmt, err := g_db.Prepare("DELETE FROM buckets WHERE name=?")
-    if err != nil {
-    	return err
-    }
-	_, err = stmt.Exec(bucket)
-    if err != nil {
-    	return err
-    }
+// Lists all buckets along with the number and total size of their objects,
+// using a single query
+func (b *SiaBridge) ListBucketsWithCounts() (buckets []BucketSummary, e error) {
+	rows, err := g_db.Query("SELECT b.name, b.created, b.quota_bytes, b.max_objects, COALESCE(o.objects,0), COALESCE(o.bytes,0) " +
+		"FROM buckets b LEFT JOIN (SELECT bucket, COUNT(*) AS objects, SUM(size) AS bytes FROM objects GROUP BY bucket) o ON o.bucket = b.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	return nil
-}
+	for rows.Next() {
+		var bs BucketSummary
+		var created int64
+		err = rows.Scan(&bs.Name, &created, &bs.QuotaBytes, &bs.MaxObjects, &bs.Objects, &bs.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		bs.Created = fromMillis(created)
+		buckets = append(buckets, bs)
+	}
 
-// Returns a list of objects in the bucket provided
-func (b *SiaBridge) ListObjects(bucket string) (objects []ObjectInfo, e error) {
-	rows, err := g_db.Query("SELECT name,size,queued,uploaded,purge_after,cached_fetches,sia_fetches,last_fetch FROM objects WHERE bucket=?",bucket)
-    if err != nil {
-    	return objects, err
-    }
+	return buckets, rows.Err()
+}
 
-    var name string
-    var size int64
-    var queued int64
-    var uploaded int64
-    var purge_after int64
-    var cached_fetches int64
-    var sia_fetches int64
-    var last_fetch int64
+// Limits the total size in bytes of the objects in a bucket. Uploads that
+// would take the bucket over its quota fail with ErrQuotaExceeded. A quota of
+// 0 means no limit.
+func (b *SiaBridge) SetBucketQuota(bucket string, quotaBytes int64) error {
+	return b.setBucketLimit(bucket, "quota_bytes", quotaBytes)
+}
 
-    for rows.Next() {
-        err = rows.Scan(&name, &size, &queued, &uploaded, &purge_after, &cached_fetches, &sia_fetches, &last_fetch)
-        if err != nil {
-        	return objects, err
-        }
+// Limits the number of objects in a bucket. Uploads that would take the bucket
+// over the limit fail with ErrObjectQuotaExceeded. A limit of 0 means no limit.
+func (b *SiaBridge) SetBucketMaxObjects(bucket string, maxObjects int64) error {
+	return b.setBucketLimit(bucket, "max_objects", maxObjects)
+}
 
-        objects = append(objects, ObjectInfo{
-        	Bucket:			bucket,
-    		Name:			name,
-    		Size:       	size,
-    		Queued:         time.Unix(queued, 0),
-    		Uploaded: 		time.Unix(uploaded, 0),
-    		PurgeAfter:		purge_after,
-    		CachedFetches:	cached_fetches,
-    		SiaFetches:		sia_fetches,
-    		LastFetch:  	time.Unix(last_fetch, 0),
-    	})
-    }
+// Sets one of a bucket's quota columns
+func (b *SiaBridge) setBucketLimit(bucket string, column string, limit int64) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
 
-    rows.Close()
+	res, err := g_db.Exec("UPDATE buckets SET "+column+"=? WHERE name=?", limit, bucket)
+	if err != nil {
+		return err
+	}
 
-	return objects, nil
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrBucketNotFound
+	}
+	return nil
 }
 
-// Returns info for the provided object
-func (b *SiaBridge) GetObjectInfo(bucket string, objectName string) (objInfo ObjectInfo, e error) {
-	// Query the database
-	var size int64
-	var queued int64
-	var uploaded int64
-	var purge_after int64
-	var cached_fetches int64
-	var sia_fetches int64
-	var last_fetch int64
-	err := g_db.QueryRow("SELECT size,queued,uploaded,purge_after,cached_fetches,sia_fetches,last_fetch FROM objects WHERE name=? AND bucket=?", objectName, bucket).Scan(&size,&queued,&uploaded,&purge_after,&cached_fetches,&sia_fetches,&last_fetch)
-	switch {
-	case err == sql.ErrNoRows:
-		return objInfo, errors.New("Object does not exist in bucket")
-	case err != nil:
-		// An error occured
-		return objInfo, err
-	default:
-		// Object exists
-		objInfo.Bucket = bucket
-		objInfo.Name = objectName
-		objInfo.Size = size
-		objInfo.Queued = time.Unix(queued,0)
-		objInfo.Uploaded = time.Unix(uploaded,0)
-		objInfo.PurgeAfter = purge_after
-		objInfo.CachedFetches = cached_fetches
-		objInfo.SiaFetches = sia_fetches
-		objInfo.LastFetch = time.Unix(last_fetch,0)
-		return objInfo, nil 	
+// Returns the current usage of a bucket along with its quota
+func (b *SiaBridge) GetBucketStats(bucket string) (stats BucketStats, e error) {
+	bi, err := b.GetBucketInfo(bucket)
+	if err != nil {
+		return stats, err
 	}
 
-	// Shouldn't happen, but just in case
-	return objInfo, errors.New("Unknown error in GetObjectInfo()")
+	stats.Name = bucket
+	stats.QuotaBytes = bi.QuotaBytes
+	stats.MaxObjects = bi.MaxObjects
+	err = g_db.QueryRow("SELECT COUNT(*), COALESCE(SUM(size),0) FROM objects WHERE bucket=?", bucket).Scan(&stats.Objects, &stats.Bytes)
+	return stats, err
 }
 
-// Writes the object identified by the bucket and object name to the writer provided
-func (b *SiaBridge) GetObject(bucket string, objectName string, writer io.Writer) error {
-	// Make sure object exists in database
-	objInfo, err := b.GetObjectInfo(bucket, objectName)
+// Returns the stats recorded at or after the time provided, oldest first.
+// Stats are only recorded while StatsInterval is set.
+func (b *SiaBridge) GetStatsHistory(since time.Time) (samples []StatsSample, e error) {
+	rows, err := g_db.Query("SELECT at, objects, bytes, cache_bytes FROM stats_history WHERE at>=? ORDER BY at", toMillis(since))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	// Prefer to deliver object from cache if available.
-	// This avoids Sia network fees and excess latency.
-	var siaObj = bucket + "/" + objectName
-	var cachedFile = filepath.Join(b.CacheDir,siaObj)
-	if _, err := os.Stat(cachedFile); err == nil {
-    	reader, err := os.Open(cachedFile)
+	for rows.Next() {
+		var sample StatsSample
+		var at int64
+		err = rows.Scan(&at, &sample.Objects, &sample.Bytes, &sample.CacheBytes)
 		if err != nil {
-		 	return err
+			return nil, err
 		}
+		sample.Time = fromMillis(at)
+		samples = append(samples, sample)
+	}
 
-		_, err = io.Copy(writer, reader)
-		reader.Close()
-    	if err != nil {
-        	return err
-    	}
-
-    	// Increment cached fetch count
-    	err = b.updateCachedFetches(bucket, objectName, objInfo.CachedFetches+1)
-    	return err
-    }
+	return samples, rows.Err()
+}
 
-    // Object not in cache, must download from Sia.
-    // First, though, make sure the file was completely uploaded to Sia.
-    if objInfo.Uploaded == time.Unix(0,0) {
-    	// File never completed uploaded, or was never marked as uploaded in database
-    	return errors.New("Attempting to download incomplete file from Sia")
-    }
+// Returns the estimated Siacoin cost (e.g., "1.5 SC") of storing the object
+// on Sia for the duration provided, at siad's current storage price
+func (b *SiaBridge) EstimateStorageCost(bucket string, objectName string, duration time.Duration) (string, error) {
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return "", err
+	}
+	return b.storageCost(objInfo.StoredSize, duration)
+}
 
-    // Make sure bucket path exists in cache directory
-	os.Mkdir(filepath.Join(b.CacheDir, bucket), 0744)
+// Returns the estimated Siacoin cost of storing all of a bucket's objects on
+// Sia for the duration provided, at siad's current storage price
+func (b *SiaBridge) EstimateBucketStorageCost(bucket string, duration time.Duration) (string, error) {
+	var bytes int64
+	err := g_db.QueryRow("SELECT COALESCE(SUM(stored_size),0) FROM objects WHERE bucket=?", bucket).Scan(&bytes)
+	if err != nil {
+		return "", err
+	}
+	return b.storageCost(bytes, duration)
+}
 
-	err = get(b.SiadAddress, "/renter/download/" + siaObj + "?destination=" + abs(cachedFile))
+// Returns the estimated Siacoin cost of storing every object in the bridge on
+// Sia for the duration provided, at siad's current storage price
+func (b *SiaBridge) EstimateTotalStorageCost(duration time.Duration) (string, error) {
+	var bytes int64
+	err := g_db.QueryRow("SELECT COALESCE(SUM(stored_size),0) FROM objects").Scan(&bytes)
 	if err != nil {
-		return err
+		return "", err
+	}
+	return b.storageCost(bytes, duration)
+}
+
+// Delete a bucket, as well as all contents of the bucket
+func (b *SiaBridge) DeleteBucket(bucket string) error {
+	if b.ReadOnly {
+		return ErrReadOnly
 	}
 
-	reader, err := os.Open(abs(cachedFile))
+	stmt, err := g_db.Prepare("DELETE FROM buckets WHERE name=?")
     if err != nil {
-        return err
+    	return err
     }
-
-    _, err = io.Copy(writer, reader)
-    reader.Close()
+	_, err = stmt.Exec(bucket)
     if err != nil {
-        return err
+    	return err
     }
 
-    // Increment sia fetch count
-	err = b.updateCachedFetches(bucket, objectName, objInfo.CachedFetches+1)
-	return err
+	return nil
 }
 
-// Uploads the data from the io.Reader to the bucket and object name specified
-func (b *SiaBridge) PutObjectFromReader(data io.Reader, bucket string, objectName string, size int64, purge_after int64) error {
-	// Make sure an object of same name doesn't already exist in bucket
-	exists, err := b.objectExists(bucket, objectName)
+// Renames a bucket, including the Sia paths and cached copies of all its
+// objects. Objects still uploading can't be moved, so the rename is refused
+// until they finish. Open multipart uploads complete into the renamed bucket.
+func (b *SiaBridge) RenameBucket(oldName string, newName string) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	err := checkBucketName(newName)
 	if err != nil {
 		return err
 	}
-	if exists {
-		return errors.New("Object with same name already exists in bucket")
-	}
-
-	// Copy the file to cache directory for Sia upload
-	var siaObj = bucket + "/" + objectName
-    var tmpPath = filepath.Join(b.CacheDir, siaObj)
-
-    // Make sure bucket path exists
-	os.Mkdir(filepath.Join(b.CacheDir, bucket), 0744)
 
-	err = copyFile(data, abs(tmpPath))
+	_, err = b.GetBucketInfo(oldName)
 	if err != nil {
 		return err
 	}
 
-	// Create a database entry for the object
-	err = b.insertObject(bucket, objectName, size, time.Now().Unix(), 0, purge_after)
+	exists, err := b.bucketExists(newName)
 	if err != nil {
 		return err
 	}
+	if exists {
+		return errors.New("Bucket with same name already exists")
+	}
 
-	// Tell Sia daemon to upload the object
-	err = post(b.SiadAddress, "/renter/upload/"+siaObj, "source="+abs(tmpPath))
+	objects, err := b.ListObjects(oldName)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if !obj.IsUploaded() {
+			return errors.New("Bucket has objects still uploading to Sia")
+		}
+	}
+
+	// Rename the files on Sia first. If any rename fails, put back the ones
+	// already renamed so Sia and the database still agree.
+	newPaths := make([]string, len(objects))
+	for i, obj := range objects {
+		newPaths[i] = siaPath(newName, obj.Name)
+		err = b.renameSiaFile(obj.SiaPath, newPaths[i])
+		if err != nil {
+			for j := 0; j < i; j++ {
+				b.renameSiaFile(newPaths[j], objects[j].SiaPath)
+			}
+			return err
+		}
+	}
+
+	err = b.renameBucketRecords(oldName, newName, objects, newPaths)
+	if err != nil {
+		for i, obj := range objects {
+			b.renameSiaFile(newPaths[i], obj.SiaPath)
+		}
+		return err
+	}
+
+	for _, obj := range objects {
+		b.recordEvent(EventRename, oldName, obj.Name, newName)
+	}
+
+	// Move cached copies to match. A copy that can't be moved is just dropped,
+	// since it can be downloaded again from Sia.
+	for i, obj := range objects {
+		src := b.cachePath(obj)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+
+		obj.SiaPath = newPaths[i]
+		dst := b.cachePath(obj)
+		os.MkdirAll(filepath.Dir(dst), 0744)
+		err = moveFile(src, dst)
+		if err != nil {
+			os.Remove(src)
+		}
+	}
+
+	return nil
+}
+
+// Moves an object to another bucket, keeping its name, statistics and
+// timestamps. Its file on Sia and any cached copy are moved to match. Objects
+// still uploading can't be moved.
+func (b *SiaBridge) MoveObjectToBucket(srcBucket string, objectName string, dstBucket string) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	objInfo, err := b.GetObjectInfo(srcBucket, objectName)
+	if err != nil {
+		return err
+	}
+	if !objInfo.IsUploaded() {
+		return ErrUploadInProgress
+	}
+
+	exists, err := b.bucketExists(dstBucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrBucketNotFound
+	}
+
+	exists, err = b.objectExists(dstBucket, objectName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrObjectExists
+	}
+
+	err = checkQuota(g_db, dstBucket, objInfo.Size)
+	if err != nil {
+		return err
+	}
+
+	// Rename the file on Sia first, and put it back if the database can't
+	// be updated so Sia and the database still agree
+	newPath := siaPath(dstBucket, objectName)
+	err = b.renameSiaFile(objInfo.SiaPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	err = b.moveObjectRecord(objInfo, dstBucket, newPath)
+	if err != nil {
+		b.renameSiaFile(newPath, objInfo.SiaPath)
+		if isUniqueViolation(err) {
+			return ErrObjectExists
+		}
+		return err
+	}
+
+	b.recordEvent(EventRename, srcBucket, objectName, dstBucket)
+
+	// Move any cached copy to match. A copy that can't be moved is just
+	// dropped, since it can be downloaded again from Sia.
+	src := b.cachePath(objInfo)
+	if _, err := os.Stat(src); err == nil {
+		moved := objInfo
+		moved.SiaPath = newPath
+		dst := b.cachePath(moved)
+		os.MkdirAll(filepath.Dir(dst), 0744)
+		if moveFile(src, dst) != nil {
+			os.Remove(src)
+		}
+	}
+
+	return nil
+}
+
+// Copies an object to a new bucket and name, and returns the info recorded for
+// the copy. The copy is made from the source's cached data, downloading it from
+// Sia into the cache first if it isn't cached, and is uploaded to Sia as a new
+// file, so it's billed like any other upload. There's no deduplication of
+// content: whatever the configuration, the copy never shares the source's Sia
+// file (DeduplicateObjects only removes duplicate database records). It keeps
+// the source's PurgeAfter, compression, encryption and ACL, but not its
+// statistics, retention or expiry.
+func (b *SiaBridge) CopyObject(srcBucket string, srcName string, dstBucket string, dstName string) (ObjectInfo, error) {
+	if b.ReadOnly {
+		return ObjectInfo{}, ErrReadOnly
+	}
+
+	objInfo, err := b.GetObjectInfo(srcBucket, srcName)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	cachedFile := b.cachePath(objInfo)
+	if _, err := os.Stat(cachedFile); err != nil {
+		// An uploading object's data can't be downloaded from Sia yet
+		if !objInfo.IsUploaded() {
+			return ObjectInfo{}, ErrUploadInProgress
+		}
+		err = b.downloadToCache(objInfo, cachedFile)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+	}
+
+	data, err := b.openObjectData(objInfo, abs(cachedFile))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer data.Close()
+
+	return b.PutObjectWithOptions(data, dstBucket, dstName, objInfo.Size, PutOptions{
+		PurgeAfter: objInfo.PurgeAfter,
+		Compress: objInfo.Compressed,
+		Encrypt: objInfo.Nonce != nil,
+		ACL: objInfo.ACL,
+	})
+}
+
+// Returns a list of objects in the bucket provided
+func (b *SiaBridge) ListObjects(bucket string) (objects []ObjectInfo, e error) {
+	return b.listObjectsWhere("bucket=?", bucket)
+}
+
+// Returns the objects in the bucket provided along with whether each one's data
+// is currently cached. Each cache directory is read once, rather than checking
+// for every object's file separately.
+func (b *SiaBridge) ListObjectsDetailed(bucket string) (details []ObjectDetail, e error) {
+	objects, err := b.ListObjects(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make(map[string]map[string]bool)
+	for _, obj := range objects {
+		path := b.cachePath(obj)
+		dir := filepath.Dir(path)
+
+		names, ok := dirs[dir]
+		if !ok {
+			names, err = readDirNames(dir)
+			if err != nil {
+				return nil, err
+			}
+			dirs[dir] = names
+		}
+
+		details = append(details, ObjectDetail{ObjectInfo: obj, Cached: names[filepath.Base(path)]})
+	}
+
+	return details, nil
+}
+
+// Returns the objects in the bucket provided whose size in bytes is between
+// minBytes and maxBytes, inclusive
+func (b *SiaBridge) ListObjectsBySize(bucket string, minBytes int64, maxBytes int64) (objects []ObjectInfo, e error) {
+	return b.listObjectsWhere("bucket=? AND size BETWEEN ? AND ?", bucket, minBytes, maxBytes)
+}
+
+// Returns the objects in the bucket provided that have never been fetched,
+// from the cache or from Sia, oldest first
+func (b *SiaBridge) ListNeverFetchedObjects(bucket string) (objects []ObjectInfo, e error) {
+	return b.listObjectsWhere("bucket=? AND cached_fetches=0 AND sia_fetches=0 ORDER BY queued", bucket)
+}
+
+// Returns up to limit objects from all buckets with the most fetches, from the
+// cache and Sia combined, most fetched first
+func (b *SiaBridge) TopObjects(limit int) (objects []ObjectInfo, e error) {
+	return b.listObjectsWhere("1=1 ORDER BY cached_fetches + sia_fetches DESC LIMIT ?", limit)
+}
+
+// Returns up to limit objects in the bucket provided with the most fetches,
+// most fetched first
+func (b *SiaBridge) TopBucketObjects(bucket string, limit int) (objects []ObjectInfo, e error) {
+	return b.listObjectsWhere("bucket=? ORDER BY cached_fetches + sia_fetches DESC LIMIT ?", bucket, limit)
+}
+
+// Returns the objects in all buckets that were queued for upload before the
+// time provided and still haven't finished uploading, oldest first. Uploads
+// pending far longer than usual may have been dropped by siad, and can be
+// retried with RestoreToSia.
+func (b *SiaBridge) ListObjectsQueuedBefore(t time.Time) (objects []ObjectInfo, e error) {
+	return b.listObjectsWhere("uploaded IS NULL AND queued<? ORDER BY queued", toMillis(t))
+}
+
+// Returns the objects in the bucket provided whose names match the shell-style
+// pattern, as defined by path.Match (e.g., "logs/*.gz"). Only objects whose
+// names begin with the pattern's literal prefix are read from the database.
+func (b *SiaBridge) ListObjectsGlob(bucket string, pattern string) (objects []ObjectInfo, e error) {
+	// Reject a bad pattern even if no object would be matched against it
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	prefix := pattern
+	if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+		prefix = pattern[:i]
+	}
+
+	// LIKE ignores case in SQLite and MySQL, so the rows are only candidates
+	err := b.forEachObjectWhere(func(obj ObjectInfo) error {
+		if !strings.HasPrefix(obj.Name, prefix) {
+			return nil
+		}
+		if matched, _ := path.Match(pattern, obj.Name); matched {
+			objects = append(objects, obj)
+		}
+		return nil
+	}, "bucket=? AND name LIKE ? ESCAPE '!'", bucket, likePrefix(prefix))
+	return objects, err
+}
+
+// Returns a folder view of the bucket provided, as S3 does for a list with a
+// delimiter. Objects whose names begin with prefix and have no delimiter after
+// it are returned in objects. For the other names beginning with prefix, the
+// part up to and including the first delimiter after prefix is returned once
+// in commonPrefixes. Both are sorted by name, and are found in a single scan
+// of the names beginning with prefix. An empty delimiter returns every object
+// beginning with prefix.
+func (b *SiaBridge) ListObjectsWithDelimiter(bucket string, prefix string, delimiter string) (objects []ObjectInfo, commonPrefixes []string, e error) {
+	// LIKE ignores case in SQLite and MySQL, so the rows are only candidates,
+	// and a case-insensitive ORDER BY may interleave names that differ in case
+	seen := make(map[string]bool)
+	err := b.forEachObjectWhere(func(obj ObjectInfo) error {
+		if !strings.HasPrefix(obj.Name, prefix) {
+			return nil
+		}
+		rest := obj.Name[len(prefix):]
+		i := -1
+		if delimiter != "" {
+			i = strings.Index(rest, delimiter)
+		}
+		if i < 0 {
+			objects = append(objects, obj)
+			return nil
+		}
+
+		common := prefix + rest[:i+len(delimiter)]
+		if !seen[common] {
+			seen[common] = true
+			commonPrefixes = append(commonPrefixes, common)
+		}
+		return nil
+	}, "bucket=? AND name LIKE ? ESCAPE '!' ORDER BY name", bucket, likePrefix(prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	return objects, commonPrefixes, nil
+}
+
+// Returns the objects in the bucket provided whose records were created or
+// changed at or after the time provided, for incremental syncing. Objects
+// deleted since then are returned by ListObjectsDeletedSince.
+func (b *SiaBridge) ListObjectsModifiedSince(bucket string, since time.Time) (objects []ObjectInfo, e error) {
+	return b.listObjectsWhere("bucket=? AND modified>=? ORDER BY modified", bucket, toMillis(since))
+}
+
+// Returns the objects deleted from the bucket provided at or after the time
+// provided, including those moved out of it by RenameBucket
+func (b *SiaBridge) ListObjectsDeletedSince(bucket string, since time.Time) (deleted []DeletedObject, e error) {
+	rows, err := g_db.Query("SELECT bucket, name, deleted FROM deleted_objects WHERE bucket=? AND deleted>=? ORDER BY deleted", bucket, toMillis(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d DeletedObject
+		var at int64
+		err = rows.Scan(&d.Bucket, &d.Name, &at)
+		if err != nil {
+			return nil, err
+		}
+		d.Deleted = fromMillis(at)
+		deleted = append(deleted, d)
+	}
+
+	return deleted, rows.Err()
+}
+
+// Calls fn for each object in the bucket provided, streaming rows from the
+// database rather than building a list in memory. Iteration stops at the first
+// error returned by fn, and that error is returned.
+func (b *SiaBridge) ForEachObject(bucket string, fn func(ObjectInfo) error) error {
+	return b.forEachObjectWhere(fn, "bucket=?", bucket)
+}
+
+// Writes the listing of the bucket provided to w in the format provided. Rows
+// are streamed from the database and written one object at a time, so large
+// buckets aren't held in memory. Times are written in RFC 3339 format, with
+// unset times left empty in CSV and null in JSON.
+func (b *SiaBridge) ExportObjectList(bucket string, w io.Writer, format ListFormat) error {
+	switch format {
+	case FormatCSV:
+		return b.exportObjectListCSV(bucket, w)
+	case FormatJSON:
+		return b.exportObjectListJSON(bucket, w)
+	default:
+		return ErrUnknownListFormat
+	}
+}
+
+func (b *SiaBridge) exportObjectListCSV(bucket string, w io.Writer) error {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"bucket", "name", "size", "queued", "uploaded", "last_fetch", "cached_fetches", "sia_fetches", "purge_after", "pinned", "id"})
+	if err != nil {
+		return err
+	}
+
+	err = b.ForEachObject(bucket, func(obj ObjectInfo) error {
+		return cw.Write([]string{
+			obj.Bucket,
+			obj.Name,
+			strconv.FormatInt(obj.Size, 10),
+			formatTime(&obj.Queued),
+			formatTime(obj.Uploaded),
+			formatTime(obj.LastFetch),
+			strconv.FormatInt(obj.CachedFetches, 10),
+			strconv.FormatInt(obj.SiaFetches, 10),
+			strconv.FormatInt(obj.PurgeAfter, 10),
+			strconv.FormatBool(obj.Pinned),
+			obj.ID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (b *SiaBridge) exportObjectListJSON(bucket string, w io.Writer) error {
+	_, err := io.WriteString(w, "[")
+	if err != nil {
+		return err
+	}
+
+	first := true
+	err = b.ForEachObject(bucket, func(obj ObjectInfo) error {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if !first {
+			_, err = io.WriteString(w, ",")
+			if err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+// Returns the objects in all buckets whose data is currently in the cache
+func (b *SiaBridge) ListCachedObjects() (objects []ObjectInfo, e error) {
+	err := b.forEachObjectWhere(func(obj ObjectInfo) error {
+		if _, err := os.Stat(b.cachePath(obj)); err == nil {
+			objects = append(objects, obj)
+		}
+		return nil
+	}, "1=1")
+	return objects, err
+}
+
+// Returns whether the object's data is currently in the cache
+func (b *SiaBridge) IsCached(bucket string, objectName string) (bool, error) {
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(b.cachePath(objInfo))
+	switch {
+	case err == nil:
+		return true, nil
+	case os.IsNotExist(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Returns nil if the object can be fetched now, from the cache or from Sia.
+// Otherwise returns ErrObjectNotYetAvailable if siad is still uploading the
+// object, or ErrObjectLost if siad has no record of it either. A lost object
+// has to be uploaded again, and CleanupFailedUploads can find such objects.
+func (b *SiaBridge) CheckObjectAvailable(bucket string, objectName string) error {
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return err
+	}
+	if objInfo.IsUploaded() {
+		return nil
+	}
+	if _, err := os.Stat(b.cachePath(objInfo)); err == nil {
+		return nil
+	}
+
+	// Not cached, so it can only become available if siad has the file
+	var rf api.RenterFiles
+	err = getAPI(b.SiadAddress, "/renter/files", &rf)
+	if err != nil {
+		return err
+	}
+	for _, file := range rf.Files {
+		if file.SiaPath == objInfo.SiaPath {
+			return ErrObjectNotYetAvailable
+		}
+	}
+	return ErrObjectLost
+}
+
+// Downloads the named objects in the bucket into the cache, running up to
+// concurrency downloads at once, and returns the result for each name. The
+// result is nil if the object is now cached, including if it already was.
+// Fetch statistics aren't changed.
+func (b *SiaBridge) WarmObjects(bucket string, names []string, concurrency int) map[string]error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make(map[string]error)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	jobs := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				objInfo, err := b.GetObjectInfo(bucket, name)
+				if err == nil {
+					err = b.warmObject(objInfo)
+				}
+
+				mutex.Lock()
+				results[name] = err
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, name := range names {
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// Keeps the object in the cache until UnpinObject is called, regardless of its
+// PurgeAfter, PurgePolicy, ShouldPurge and ReclaimCache. Pinning an object that
+// isn't cached doesn't fetch it.
+func (b *SiaBridge) PinObject(bucket string, objectName string) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	return b.updatePinned(bucket, objectName, true)
+}
+
+// Lets the object be purged from the cache again after PinObject
+func (b *SiaBridge) UnpinObject(bucket string, objectName string) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	return b.updatePinned(bucket, objectName, false)
+}
+
+// Sets who the object may be served to. Returns ErrInvalidACL unless acl is
+// ACLPrivate or ACLPublicRead.
+func (b *SiaBridge) SetObjectACL(bucket string, objectName string, acl ObjectACL) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if !acl.valid() {
+		return ErrInvalidACL
+	}
+
+	res, err := g_db.Exec("UPDATE objects SET acl=?, modified=? WHERE bucket=? AND name=?", string(acl), toMillis(time.Now()), bucket, objectName)
+	if err != nil {
+		return fmt.Errorf("SetObjectACL: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("Object does not exist in bucket")
+	}
+	return nil
+}
+
+// Sets the object's free-text description, replacing any it had
+func (b *SiaBridge) SetObjectDescription(bucket string, objectName string, desc string) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	res, err := g_db.Exec("UPDATE objects SET description=?, modified=? WHERE bucket=? AND name=?", desc, toMillis(time.Now()), bucket, objectName)
+	if err != nil {
+		return fmt.Errorf("SetObjectDescription: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("Object does not exist in bucket")
+	}
+	return nil
+}
+
+// Returns who the object may be served to
+func (b *SiaBridge) GetObjectACL(bucket string, objectName string) (ObjectACL, error) {
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return ACLPrivate, err
+	}
+	return objInfo.ACL, nil
+}
+
+// Returns whether the ACL is one of the supported values
+func (acl ObjectACL) valid() bool {
+	return acl == ACLPrivate || acl == ACLPublicRead
+}
+
+// Uploads the object to Sia again from its cached copy, for when siad has lost
+// the file, e.g. because too few hosts held it. Any file siad still has at the
+// object's siapath is replaced. The object is marked not uploaded, so it stays
+// in the cache until the manager sees the new upload complete. Returns
+// ErrObjectNotCached if the object's data isn't in the cache.
+func (b *SiaBridge) RestoreToSia(bucket string, objectName string) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	g_cache_mutex.RLock()
+	defer g_cache_mutex.RUnlock()
+
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return err
+	}
+
+	path := b.cachePath(objInfo)
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return ErrObjectNotCached
+	}
+	if err != nil {
+		return err
+	}
+
+	// Keep the cached copy from being purged before the upload completes
+	err = b.markObjectNotUploaded(bucket, objectName)
+	if err != nil {
+		return err
+	}
+
+	// Siad may have no record of a lost file, so a failed delete is expected
+	post(b.SiadAddress, "/renter/delete/"+escapeSiaPath(objInfo.SiaPath), "")
+
+	return b.startSiaUpload(objInfo.SiaPath, path)
+}
+
+// Returns the state of the object's upload to Sia, based on both the object's
+// uploaded time and siad's record of its file. An object whose file siad no
+// longer has is UploadStateFailed even if it was uploaded.
+func (b *SiaBridge) UploadState(bucket string, objectName string) (UploadState, error) {
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return UploadStateFailed, err
+	}
+
+	var rf api.RenterFiles
+	err = getAPI(b.SiadAddress, "/renter/files", &rf)
+	if err != nil {
+		return UploadStateFailed, err
+	}
+
+	for _, file := range rf.Files {
+		if file.SiaPath != objInfo.SiaPath {
+			continue
+		}
+		// The manager may not have marked a completed upload yet
+		if objInfo.IsUploaded() || b.uploadComplete(file) {
+			return UploadStateComplete, nil
+		}
+		return UploadStateInProgress, nil
+	}
+	return UploadStateFailed, nil
+}
+
+// Checks whether each object in the bucket can be fetched from Sia, without
+// downloading any data, and returns the result for each object name. The result
+// is nil if siad reports the object's file available, ErrObjectNotYetAvailable
+// if it's still uploading, ErrObjectUnavailable if an uploaded object's file is
+// no longer available, or ErrObjectLost if siad has no record of it. Cached
+// copies aren't considered, so this shows whether the bucket could be restored
+// from Sia alone. An error is returned if the objects can't be listed or siad
+// can't be asked about them.
+func (b *SiaBridge) VerifyBucket(bucket string) (results map[string]error, e error) {
+	objects, err := b.ListObjects(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := renterFileMap(b.SiadAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	results = make(map[string]error)
+
+	for _, obj := range objects {
+		file, ok := files[obj.SiaPath]
+		switch {
+		case !ok:
+			results[obj.Name] = ErrObjectLost
+		case !obj.IsUploaded():
+			results[obj.Name] = ErrObjectNotYetAvailable
+		case !file.Available:
+			results[obj.Name] = ErrObjectUnavailable
+		default:
+			results[obj.Name] = nil
+		}
+	}
+
+	return results, nil
+}
+
+// Returns info for the object with the ID provided, wherever it has been
+// renamed or moved to
+func (b *SiaBridge) GetObjectInfoByID(id string) (objInfo ObjectInfo, e error) {
+	row := g_db.QueryRow("SELECT "+objectColumns+" FROM objects WHERE id=?", id)
+	objInfo, err := scanObject(row)
+	if err == sql.ErrNoRows {
+		return objInfo, errors.New("Object does not exist")
+	}
+	return objInfo, err
+}
+
+// Writes the data of the object with the ID provided to the writer, as
+// GetObject does
+func (b *SiaBridge) GetObjectByID(id string, writer io.Writer) error {
+	objInfo, err := b.GetObjectInfoByID(id)
+	if err != nil {
+		return err
+	}
+	return b.GetObject(objInfo.Bucket, objInfo.Name, writer)
+}
+
+// Returns info for the provided object
+func (b *SiaBridge) GetObjectInfo(bucket string, objectName string) (objInfo ObjectInfo, e error) {
+	// Query the database
+	row := g_db.QueryRow("SELECT "+objectColumns+" FROM objects WHERE name=? AND bucket=?", objectName, bucket)
+	objInfo, err := scanObject(row)
+	switch {
+	case err == sql.ErrNoRows:
+		return objInfo, errors.New("Object does not exist in bucket")
+	case err != nil:
+		// An error occured
+		return objInfo, err
+	default:
+		// Object exists
+		return objInfo, nil
+	}
+}
+
+// Returns the siapath the object is stored under on the Sia network, for use
+// with tools that talk to siad directly
+func (b *SiaBridge) SiaPath(bucket string, objectName string) (string, error) {
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return "", err
+	}
+	return objInfo.SiaPath, nil
+}
+
+// Writes the object identified by the bucket and object name to the writer provided
+func (b *SiaBridge) GetObject(bucket string, objectName string, writer io.Writer) error {
+	// Make sure object exists in database
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return err
+	}
+
+	return b.writeObject(objInfo, writer)
+}
+
+// Writes the object identified by the bucket and object name to the writer
+// provided, as GetObject does, but using cacheRoot as the cache directory for
+// this call only. The object is downloaded from Sia into cacheRoot unless it's
+// already there; the bridge's own cache directories are left alone.
+func (b *SiaBridge) GetObjectToCacheDir(bucket string, objectName string, cacheRoot string, writer io.Writer) error {
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return err
+	}
+
+	objInfo.CacheDir = cacheRoot
+	return b.writeObject(objInfo, writer)
+}
+
+// Writes the object's data to the writer from its cache directory, downloading
+// it from Sia into the cache first if it isn't there
+func (b *SiaBridge) writeObject(objInfo ObjectInfo, writer io.Writer) error {
+	bucket, objectName := objInfo.Bucket, objInfo.Name
+
+	// Prefer to deliver object from cache if available.
+	// This avoids Sia network fees and excess latency.
+	var cachedFile = b.cachePath(objInfo)
+	err := b.checkCacheSize(objInfo, cachedFile)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(cachedFile); err == nil {
+    	reader, err := b.openObjectData(objInfo, cachedFile)
+		if err != nil {
+		 	return err
+		}
+
+		// Never send more than the recorded size, even if the file has grown
+		_, err = io.Copy(writer, io.LimitReader(reader, objInfo.Size))
+		reader.Close()
+    	if err != nil {
+        	return fmt.Errorf("GetObject %s/%s: %w", bucket, objectName, err)
+    	}
+
+    	b.recordEvent(EventFetch, bucket, objectName, "cache")
+
+    	// Increment cached fetch count
+    	err = b.updateCachedFetches(bucket, objectName)
+    	return err
+    }
+
+    // Object not in cache, must download from Sia.
+	cachedFile, err = b.fetchFromSia(objInfo, cachedFile)
+	if err != nil {
+		return err
+	}
+
+	reader, err := b.openObjectData(objInfo, abs(cachedFile))
+    if err != nil {
+        return err
+    }
+
+    _, err = io.Copy(writer, io.LimitReader(reader, objInfo.Size))
+    reader.Close()
+    if err != nil {
+        return fmt.Errorf("GetObject %s/%s: %w", bucket, objectName, err)
+    }
+
+	b.recordEvent(EventFetch, bucket, objectName, "sia")
+
+    // Increment sia fetch count
+	err = b.updateSiaFetches(bucket, objectName)
+	return err
+}
+
+// Opens the object identified by the bucket and object name for random access.
+// If the object isn't cached, it is downloaded from Sia into the cache first.
+// The caller is responsible for closing the returned reader.
+func (b *SiaBridge) OpenObject(bucket string, objectName string) (ReadSeekCloser, error) {
+	// Make sure object exists in database
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer to open the object from cache if available
+	var cachedFile = b.cachePath(objInfo)
+	err = b.checkCacheSize(objInfo, cachedFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(cachedFile); err == nil {
+		file, err := b.openObjectFile(objInfo, cachedFile)
+		if err != nil {
+			return nil, err
+		}
+
+		b.recordEvent(EventFetch, bucket, objectName, "cache")
+
+		// Increment cached fetch count
+		err = b.updateCachedFetches(bucket, objectName)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return file, nil
+	}
+
+	// Object not in cache, must download from Sia.
+	cachedFile, err = b.fetchFromSia(objInfo, cachedFile)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := b.openObjectFile(objInfo, abs(cachedFile))
+	if err != nil {
+		return nil, err
+	}
+
+	b.recordEvent(EventFetch, bucket, objectName, "sia")
+
+	// Increment sia fetch count
+	err = b.updateSiaFetches(bucket, objectName)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Returns a reader over the data of the object identified by the bucket and
+// object name, along with its size in bytes. The reader never returns more
+// than that many bytes. If the object isn't cached, it is downloaded from Sia
+// into the cache first. Unlike OpenObject, encoded data is
+// decoded as it's read rather than up front. The caller is responsible for
+// closing the returned reader.
+func (b *SiaBridge) GetObjectReader(bucket string, objectName string) (io.ReadCloser, int64, error) {
+	// Make sure object exists in database
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Prefer to read the object from cache if available
+	var cachedFile = b.cachePath(objInfo)
+	err = b.checkCacheSize(objInfo, cachedFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := os.Stat(cachedFile); err == nil {
+		reader, err := b.openObjectData(objInfo, cachedFile)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		b.recordEvent(EventFetch, bucket, objectName, "cache")
+
+		// Increment cached fetch count
+		err = b.updateCachedFetches(bucket, objectName)
+		if err != nil {
+			reader.Close()
+			return nil, 0, err
+		}
+		return newLimitedReadCloser(reader, objInfo.Size), objInfo.Size, nil
+	}
+
+	// Object not in cache, must download from Sia.
+	cachedFile, err = b.fetchFromSia(objInfo, cachedFile)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reader, err := b.openObjectData(objInfo, abs(cachedFile))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	b.recordEvent(EventFetch, bucket, objectName, "sia")
+
+	// Increment sia fetch count
+	err = b.updateSiaFetches(bucket, objectName)
+	if err != nil {
+		reader.Close()
+		return nil, 0, err
+	}
+	return newLimitedReadCloser(reader, objInfo.Size), objInfo.Size, nil
+}
+
+// Uploads the data from the io.Reader to the bucket and object name specified
+func (b *SiaBridge) PutObjectFromReader(data io.Reader, bucket string, objectName string, size int64, purge_after int64) error {
+	_, err := b.PutObject(data, bucket, objectName, size, purge_after)
+	return err
+}
+
+// Uploads the data from the io.Reader to the bucket and object name specified,
+// and returns the info recorded for the new object
+func (b *SiaBridge) PutObject(data io.Reader, bucket string, objectName string, size int64, purge_after int64) (ObjectInfo, error) {
+	return b.PutObjectWithOptions(data, bucket, objectName, size, PutOptions{PurgeAfter: purge_after})
+}
+
+// Uploads the data from the io.Reader to the bucket and object name specified
+// using the options provided, and returns the info recorded for the new object
+func (b *SiaBridge) PutObjectWithOptions(data io.Reader, bucket string, objectName string, size int64, opts PutOptions) (objInfo ObjectInfo, e error) {
+	if b.ReadOnly {
+		return objInfo, ErrReadOnly
+	}
+
+	if opts.ACL != "" && !opts.ACL.valid() {
+		return objInfo, ErrInvalidACL
+	}
+
+	err := checkBucketName(bucket)
+	if err == nil {
+		err = checkObjectName(objectName)
+	}
+	if err != nil {
+		return objInfo, err
+	}
+
+	err = b.ensureBucket(bucket)
+	if err != nil {
+		return objInfo, err
+	}
+
+	// Make sure an object of same name doesn't already exist in bucket
+	exists, err := b.objectExists(bucket, objectName)
+	if err != nil {
+		return objInfo, err
+	}
+	if exists {
+		return objInfo, ErrObjectExists
+	}
+
+	// Reject objects known to be too large before touching the cache
+	if b.MaxObjectBytes > 0 && size > b.MaxObjectBytes {
+		return objInfo, ErrObjectTooLarge
+	}
+	err = checkQuota(g_db, bucket, size)
+	if err != nil {
+		return objInfo, err
+	}
+
+	// Keep RelocateCache from moving the cache directory until siad has
+	// been told to upload the object
+	g_cache_mutex.RLock()
+	cacheLocked := true
+	defer func() {
+		if cacheLocked {
+			g_cache_mutex.RUnlock()
+		}
+	}()
+
+	// Copy the data to a staging file of its own in the cache directory.
+	// It's only moved to the object's cache path once the object is recorded,
+	// so concurrent uploads of the same object can't overwrite each other.
+	var siaObj = siaPath(bucket, objectName)
+    var cachedFile = b.cachePath(ObjectInfo{SiaPath: siaObj})
+
+    // Make sure bucket path exists
+	os.MkdirAll(filepath.Dir(cachedFile), 0744)
+
+	// Make sure the cache filesystem has room for the object. If the size
+	// isn't known up front, keep an eye on free space during the copy instead.
+	if size > 0 {
+		free := freeDiskSpace(b.cacheDir())
+		if free >= 0 && size > free {
+			return objInfo, ErrInsufficientCacheSpace
+		}
+	} else {
+		data = &spaceCheckReader{r: data, dir: b.cacheDir()}
+	}
+
+	// Read at most one byte past the limit, so oversized data can be detected
+	if b.MaxObjectBytes > 0 {
+		data = io.LimitReader(data, b.MaxObjectBytes+1)
+	}
+
+	if b.UploadRateLimit > 0 {
+		data = newRateLimitedReader(data, b.UploadRateLimit)
+	}
+
+	var aead cipher.AEAD
+	var nonce []byte
+	if opts.Encrypt {
+		aead, err = b.newAEAD()
+		if err != nil {
+			return objInfo, err
+		}
+		nonce, err = newNonce(aead)
+		if err != nil {
+			return objInfo, err
+		}
+	}
+
+	tmpPath, err := newStagingFile(filepath.Dir(cachedFile))
+	if err != nil {
+		return objInfo, err
+	}
+
+	var written int64
+	if opts.Compress || opts.Encrypt {
+		written, err = encodeFile(data, tmpPath, opts.Compress, aead, nonce)
+	} else {
+		err = writeFile(data, tmpPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return objInfo, err
+	}
+
+	fi, err := os.Stat(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return objInfo, err
+	}
+	stored := fi.Size()
+
+	// Check the bytes actually read rather than the size the caller declared.
+	// Encoded objects are limited by their original size.
+	actual := stored
+	if opts.Compress || opts.Encrypt {
+		actual = written
+	}
+	if b.MaxObjectBytes > 0 && actual > b.MaxObjectBytes {
+		os.Remove(tmpPath)
+		return objInfo, ErrObjectTooLarge
+	}
+	if size > 0 && actual != size {
+		os.Remove(tmpPath)
+		return objInfo, ErrSizeMismatch
+	}
+	size = actual
+
+	// Create a database entry for the object
+	objInfo = ObjectInfo{
+		Bucket:			bucket,
+		Name:			objectName,
+		Size:			size,
+		Queued:			fromMillis(toMillis(time.Now())),
+		PurgeAfter:		opts.PurgeAfter,
+		SiaPath:		siaObj,
+		Compressed:		opts.Compress,
+		StoredSize:		stored,
+		Nonce:			nonce,
+	}
+	objInfo.Modified = objInfo.Queued
+	objInfo.ACL = opts.ACL
+	objInfo.Description = opts.Description
+	if !opts.RetainUntil.IsZero() {
+		retain := fromMillis(toMillis(opts.RetainUntil))
+		objInfo.RetainUntil = &retain
+	}
+	if !opts.ExpiresAt.IsZero() {
+		expires := fromMillis(toMillis(opts.ExpiresAt))
+		objInfo.ExpiresAt = &expires
+	}
+
+	// A concurrent upload of the same object may have got here first, in
+	// which case the object and its cache path are its. The quota is checked
+	// again along with the insert, since the size may not have been known up
+	// front and other uploads to the bucket may have finished since.
+	err = b.insertObjectWithinQuota(objInfo)
+	if isUniqueViolation(err) {
+		os.Remove(tmpPath)
+		return ObjectInfo{}, ErrObjectExists
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return objInfo, err
+	}
+
+	err = cacheError(os.Rename(tmpPath, abs(cachedFile)))
+	if err != nil {
+		b.discardObjectRecord(bucket, objectName)
+		os.Remove(tmpPath)
+		return ObjectInfo{}, err
+	}
+
+	// Tell Sia daemon to upload the object. If siad didn't accept the upload,
+	// undo the database entry and cached copy so no phantom object is left
+	// behind that the manager can never mark uploaded.
+	err = b.startSiaUpload(siaObj, cachedFile)
+	if err != nil {
+		b.discardObjectRecord(bucket, objectName)
+		os.Remove(abs(cachedFile))
+		return ObjectInfo{}, err
+	}
+
+	g_cache_mutex.RUnlock()
+	cacheLocked = false
+
+	b.recordEvent(EventPut, bucket, objectName, "")
+
+	if opts.WaitForSia {
+		err = b.WaitForUpload(bucket, objectName, opts.WaitTimeout)
+		if err != nil {
+			return objInfo, err
+		}
+		return b.GetObjectInfo(bucket, objectName)
+	}
+	return objInfo, nil
+}
+
+// Waits until the object has finished uploading to Sia, polling siad every
+// UPLOAD_POLL_SEC seconds, and marks it uploaded if the manager hasn't yet.
+// Returns ErrUploadTimeout if the upload hasn't finished within the timeout,
+// ErrBridgeStopped if the bridge is stopped first, or an error if the object
+// is deleted while waiting. There's no limit if timeout is 0.
+func (b *SiaBridge) WaitForUpload(bucket string, objectName string, timeout time.Duration) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return err
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	return b.watchUpload(objInfo, nil, deadline)
+}
+
+// Uploads the data from the io.Reader to the bucket and object name specified,
+// and returns a channel of events reporting the upload's progress. The data is
+// cached before returning, so the first event is always UploadCached. Siad's
+// upload progress is then polled every UPLOAD_POLL_SEC seconds until the upload
+// completes. If it hasn't completed within UploadTimeout, or the bridge is
+// stopped first, the last event is UploadFailed with ErrUploadTimeout or
+// ErrBridgeStopped, though the upload itself carries on. The channel is closed
+// after the UploadComplete or UploadFailed event, and must be drained until
+// then.
+func (b *SiaBridge) PutObjectFromReaderStream(data io.Reader, bucket string, objectName string, size int64, purge_after int64) (<-chan UploadEvent, error) {
+	objInfo, err := b.PutObject(data, bucket, objectName, size, purge_after)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan UploadEvent, 1)
+	events <- UploadEvent{Type: UploadCached, Bytes: objInfo.StoredSize}
+
+	var deadline time.Time
+	if b.UploadTimeout > 0 {
+		deadline = time.Now().Add(b.UploadTimeout)
+	}
+
+	go func() {
+		defer close(events)
+
+		err := b.watchUpload(objInfo, events, deadline)
+		if err != nil {
+			events <- UploadEvent{Type: UploadFailed, Err: err}
+			return
+		}
+		events <- UploadEvent{Type: UploadComplete}
+	}()
+
+	return events, nil
+}
+
+// Appends the data from the io.Reader to an existing object. Sia can't append
+// to a file, so the whole grown object is written to the cache (downloading it
+// from Sia first if it isn't cached), its old file is deleted from Sia, and the
+// grown object is uploaded again, paying Sia's upload cost for all of it. The
+// object's fetch statistics are kept. Returns ErrUploadInProgress if the object
+// hasn't finished uploading, and ErrObjectLocked if it's retained.
+func (b *SiaBridge) AppendToObject(bucket string, objectName string, data io.Reader) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	g_cache_mutex.RLock()
+	defer g_cache_mutex.RUnlock()
+
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return err
+	}
+	if objInfo.isLocked() {
+		return ErrObjectLocked
+	}
+
+	// Siad may still be reading the cache file of an uploading object
+	if !objInfo.IsUploaded() {
+		return ErrUploadInProgress
+	}
+
+	cachedFile := b.cachePath(objInfo)
+	if _, err := os.Stat(cachedFile); err != nil {
+		err = b.downloadToCache(objInfo, cachedFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	old, err := b.openObjectData(objInfo, abs(cachedFile))
+	if err != nil {
+		return err
+	}
+
+	if b.UploadRateLimit > 0 {
+		data = newRateLimitedReader(data, b.UploadRateLimit)
+	}
+
+	// Write the grown object beside the cached copy, which stays intact
+	// until the grown object is complete
+	tmpPath, err := newStagingFile(filepath.Dir(cachedFile))
+	if err != nil {
+		old.Close()
+		return err
+	}
+
+	updated := objInfo
+	var written int64
+	if isEncoded(objInfo) {
+		var aead cipher.AEAD
+		if objInfo.Nonce != nil {
+			// Never encrypt different data with the same nonce
+			aead, err = b.newAEAD()
+			if err == nil {
+				updated.Nonce, err = newNonce(aead)
+			}
+		}
+		if err == nil {
+			written, err = encodeFile(io.MultiReader(old, data), tmpPath, objInfo.Compressed, aead, updated.Nonce)
+		}
+	} else {
+		err = writeFile(io.MultiReader(old, data), tmpPath)
+	}
+	old.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	fi, err := os.Stat(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	updated.StoredSize = fi.Size()
+	updated.Size = updated.StoredSize
+	if isEncoded(objInfo) {
+		updated.Size = written
+	}
+
+	if b.MaxObjectBytes > 0 && updated.Size > b.MaxObjectBytes {
+		os.Remove(tmpPath)
+		return ErrObjectTooLarge
+	}
+	stats, err := b.GetBucketStats(bucket)
+	if err == nil && stats.QuotaBytes > 0 && stats.Bytes+updated.Size-objInfo.Size > stats.QuotaBytes {
+		err = ErrQuotaExceeded
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Record the grown object and move it into the cache before touching
+	// Sia, so the old file on Sia is only given up once nothing else can fail.
+	// The object isn't marked uploaded again until its new file is.
+	err = b.updateObjectData(updated)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = cacheError(os.Rename(tmpPath, abs(cachedFile)))
+	if err != nil {
+		os.Remove(tmpPath)
+		b.updateObjectData(objInfo)
+		return err
+	}
+
+	// Replace the old file on Sia with the grown object
+	err = post(b.SiadAddress, "/renter/delete/"+escapeSiaPath(objInfo.SiaPath), "")
+	if err != nil && !isUnknownPathError(err) {
+		return err
+	}
+
+	err = b.startSiaUpload(objInfo.SiaPath, cachedFile)
+	if err != nil {
+		return err
+	}
+
+	b.recordEvent(EventPut, bucket, objectName, "append")
+	return nil
+}
+
+// Uploads the data from the file specified to the bucket and object name specified
+func (b *SiaBridge) PutObjectFromFile(file string, bucket string, objectName string, purge_after int64) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	// Make sure file exists and get size in bytes
+	fi, err := os.Stat(file);
+	if err != nil {
+	    return err
+	}
+	size := fi.Size()
+	if b.MaxObjectBytes > 0 && size > b.MaxObjectBytes {
+		return ErrObjectTooLarge
+	}
+
+	// Get a reader to the file
+	data, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	err = b.PutObjectFromReader(data, bucket, objectName, size, purge_after)
+	data.Close()
+	return err
+}
+
+// Starts a multipart upload of an object, and returns its upload ID. Parts are
+// stored in the cache by UploadPart, in any order, and assembled into the
+// object by CompleteMultipartUpload.
+func (b *SiaBridge) InitMultipartUpload(bucket string, objectName string) (uploadID string, e error) {
+	if b.ReadOnly {
+		return "", ErrReadOnly
+	}
+
+	g_cache_mutex.RLock()
+	defer g_cache_mutex.RUnlock()
+
+	err := checkBucketName(bucket)
+	if err == nil {
+		err = checkObjectName(objectName)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = b.ensureBucket(bucket)
+	if err != nil {
+		return "", err
+	}
+
+	// Make sure an object of same name doesn't already exist in bucket
+	exists, err := b.objectExists(bucket, objectName)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", ErrObjectExists
+	}
+
+	uploadID, err = newRandomID()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(b.multipartDir(uploadID), 0744)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = g_db.Exec("INSERT INTO multipart_uploads(id, bucket, name, created) values(?,?,?,?)", uploadID, bucket, objectName, toMillis(time.Now()))
+	if err != nil {
+		os.RemoveAll(b.multipartDir(uploadID))
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+// Stores a part of a multipart upload in the cache. Parts are numbered from
+// 1, and uploading a part again replaces it.
+func (b *SiaBridge) UploadPart(uploadID string, partNumber int, data io.Reader, size int64) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	g_cache_mutex.RLock()
+	defer g_cache_mutex.RUnlock()
+
+	if partNumber < 1 {
+		return errors.New("Part number must be at least 1")
+	}
+
+	_, _, err := b.getMultipartUpload(uploadID)
+	if err != nil {
+		return err
+	}
+
+	if b.MaxObjectBytes > 0 && size > b.MaxObjectBytes {
+		return ErrObjectTooLarge
+	}
+	if size > 0 {
+		free := freeDiskSpace(b.cacheDir())
+		if free >= 0 && size > free {
+			return ErrInsufficientCacheSpace
+		}
+	} else {
+		data = &spaceCheckReader{r: data, dir: b.cacheDir()}
+	}
+
+	if b.UploadRateLimit > 0 {
+		data = newRateLimitedReader(data, b.UploadRateLimit)
+	}
+
+	// Replace any earlier upload of the part. The part is written to a file of
+	// its own first, so concurrent uploads of it can't interleave.
+	partFile := b.partPath(uploadID, partNumber)
+	staged, err := newStagingFile(filepath.Dir(partFile))
+	if err != nil {
+		return err
+	}
+	err = writeFile(data, staged)
+	if err == nil {
+		err = cacheError(os.Rename(staged, partFile))
+	}
+	if err != nil {
+		os.Remove(staged)
+		return err
+	}
+
+	fi, err := os.Stat(partFile)
+	if err != nil {
+		return err
+	}
+
+	tx, err := g_db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM multipart_parts WHERE upload_id=? AND part_number=?", uploadID, partNumber)
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO multipart_parts(upload_id, part_number, size) values(?,?,?)", uploadID, partNumber, fi.Size())
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Assembles the parts of a multipart upload, in part number order, into an
+// object and uploads it to Sia, purging it from the cache if it isn't fetched
+// for purge_after seconds. The parts are removed once the object exists.
+func (b *SiaBridge) CompleteMultipartUpload(uploadID string, purge_after int64) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	bucket, objectName, err := b.getMultipartUpload(uploadID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := g_db.Query("SELECT part_number, size FROM multipart_parts WHERE upload_id=? ORDER BY part_number", uploadID)
+	if err != nil {
+		return err
+	}
+
+	// Each part is opened only while it's copied, so assembling an object
+	// from many parts doesn't run out of file descriptors
+	parts := &filesReader{}
+	defer parts.Close()
+
+	var total int64
+	for rows.Next() {
+		var partNumber int
+		var size int64
+		err = rows.Scan(&partNumber, &size)
+		if err != nil {
+			break
+		}
+
+		parts.paths = append(parts.paths, b.partPath(uploadID, partNumber))
+		total += size
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	if len(parts.paths) == 0 {
+		return errors.New("Multipart upload has no parts")
+	}
+
+	_, err = b.PutObjectWithOptions(parts, bucket, objectName, total, PutOptions{PurgeAfter: purge_after})
+	if err != nil {
+		return err
+	}
+
+	return b.AbortMultipartUpload(uploadID)
+}
+
+// Cancels a multipart upload, removing its parts from the cache
+func (b *SiaBridge) AbortMultipartUpload(uploadID string) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	_, _, err := b.getMultipartUpload(uploadID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := g_db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM multipart_parts WHERE upload_id=?", uploadID)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM multipart_uploads WHERE id=?", uploadID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(b.multipartDir(uploadID))
+}
+
+// Deletes the object from the database, the cache and Sia. Returns
+// ErrUploadInProgress for an object still uploading unless CancelUploadOnDelete
+// is set.
+func (b *SiaBridge) DeleteObject(bucket string, objectName string) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	// Look up the object's Sia path before its record is gone
+	objInfo, err := b.GetObjectInfo(bucket, objectName)
+	if err != nil {
+		return err
+	}
+	if objInfo.isLocked() {
+		return ErrObjectLocked
+	}
+
+	// An upload that completes after the record is gone would leave an
+	// orphaned file on Sia, so siad's upload must be cancelled first
+	if !objInfo.IsUploaded() {
+		if !b.CancelUploadOnDelete {
+			return ErrUploadInProgress
+		}
+		err = post(b.SiadAddress, "/renter/delete/"+escapeSiaPath(objInfo.SiaPath), "")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete record from database
+	err = b.deleteObjectRecord(bucket, objectName)
+	if err != nil {
+		return err
+	}
+
+	// Remove any cached copy, which would otherwise be orphaned
+	os.Remove(abs(b.cachePath(objInfo)))
+
+	b.recordEvent(EventDelete, bucket, objectName, "")
+
+	if !objInfo.IsUploaded() {
+		return nil
+	}
+
+    // Tell Sia daemon to delete the object
+	err = post(b.SiadAddress, "/renter/delete/"+escapeSiaPath(objInfo.SiaPath), "")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Writes all bucket and object metadata (not object data) to the writer as JSON
+func (b *SiaBridge) ExportMetadata(w io.Writer) error {
+	var dump metadataDump
+	var err error
+
+	dump.Buckets, err = b.ListBuckets()
+	if err != nil {
+		return err
+	}
+
+	dump.Objects, err = b.listObjectsWhere("1=1")
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(dump)
+}
+
+// Restores bucket and object metadata written by ExportMetadata. This is meant
+// for rebuilding the index of a fresh database pointed at the same Sia renter,
+// so it fails without changing anything if any bucket or object already exists.
+func (b *SiaBridge) ImportMetadata(r io.Reader) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	var dump metadataDump
+	err := json.NewDecoder(r).Decode(&dump)
+	if err != nil {
+		return err
+	}
+
+	tx, err := g_db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range dump.Buckets {
+		_, err = tx.Exec("INSERT INTO buckets(name, created, quota_bytes, max_objects) values(?,?,?,?)", bucket.Name, toMillis(bucket.Created), bucket.QuotaBytes, bucket.MaxObjects)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, obj := range dump.Objects {
+		// Exports predating stored Sia paths used the unescaped name
+		if obj.SiaPath == "" {
+			obj.SiaPath = obj.Bucket + "/" + obj.Name
+		}
+
+		err = b.insertObject(tx, obj)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Recreates missing bucket and object records from the files known to the Sia
+// renter, for recovering from a lost database. Each siapath is parsed as
+// "bucket/objectName" with both names escaped as done by siaPath. Objects
+// uploaded before bucket names were escaped are assumed to be in buckets whose
+// names don't contain a slash, so everything after the first slash is the
+// object name. Siapaths the bridge couldn't have created, such as those
+// without a slash or with an invalid bucket or object name, are skipped and
+// returned. Objects that already exist in the database are left as is.
+func (b *SiaBridge) RebuildFromSia() (skipped []string, e error) {
+	if b.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	var rf api.RenterFiles
+	err := getAPI(b.SiadAddress, "/renter/files", &rf)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range rf.Files {
+		idx := strings.Index(file.SiaPath, "/")
+		if idx <= 0 || idx == len(file.SiaPath)-1 {
+			skipped = append(skipped, file.SiaPath)
+			continue
+		}
+		bucket, err := url.PathUnescape(file.SiaPath[:idx])
+		if err != nil {
+			// Uploaded before bucket names were escaped
+			bucket = file.SiaPath[:idx]
+		}
+		objectName, err := url.PathUnescape(file.SiaPath[idx+1:])
+		if err != nil {
+			// Uploaded before object names were escaped
+			objectName = file.SiaPath[idx+1:]
+		}
+		if checkBucketName(bucket) != nil || checkObjectName(objectName) != nil {
+			skipped = append(skipped, file.SiaPath)
+			continue
+		}
+
+		err = b.CreateBucket(bucket)
+		if err != nil {
+			return skipped, err
+		}
+
+		exists, err := b.objectExists(bucket, objectName)
+		if err != nil {
+			return skipped, err
+		}
+		if exists {
+			continue
+		}
+
+		// Files still uploading are left for the manager to mark uploaded
+		now := time.Now()
+		var uploaded *time.Time
+		if file.Available {
+			uploaded = &now
+		}
+
+		err = b.insertObject(g_db, ObjectInfo{
+			Bucket:			bucket,
+			Name:			objectName,
+			Size:			int64(file.Filesize),
+			Queued:			now,
+			Uploaded:		uploaded,
+			PurgeAfter:		REBUILD_PURGE_AFTER_SEC,
+			SiaPath:		file.SiaPath,
+			StoredSize:		int64(file.Filesize),
+		})
+		if err != nil {
+			return skipped, err
+		}
+	}
+
+	return skipped, nil
+}
+
+// What CleanupFailedUploads does with objects siad never received
+type CleanupPolicy int
+
+const (
+	// Remove the object's record and cached copy
+	CleanupRemove CleanupPolicy = iota
+	// Upload the object again from its cached copy, or remove it if the
+	// cached copy is gone
+	CleanupRetry
+)
+
+// Finds objects queued for upload more than olderThan ago that siad has no
+// record of, which happens when siad rejected the upload request, and handles
+// them according to the policy. Returns the "bucket/objectName" of each
+// object removed.
+func (b *SiaBridge) CleanupFailedUploads(olderThan time.Duration, policy CleanupPolicy) (removed []string, e error) {
+	if b.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	g_cache_mutex.RLock()
+	defer g_cache_mutex.RUnlock()
+
+	cutoff := toMillis(time.Now().Add(-olderThan))
+	objs, err := b.listObjectsWhere("uploaded IS NULL AND queued<?", cutoff)
+	if err != nil || len(objs) == 0 {
+		return removed, err
+	}
+
+	// Get all renter files
+	files, err := renterFileMap(b.SiadAddress)
+	if err != nil {
+		return removed, err
+	}
+
+	for _, obj := range objs {
+		if _, ok := files[obj.SiaPath]; ok {
+			continue // Still uploading
+		}
+		if obj.isLocked() {
+			continue
+		}
+
+		cachedFile := b.cachePath(obj)
+		if policy == CleanupRetry {
+			if _, err := os.Stat(cachedFile); err == nil {
+				err = b.startSiaUpload(obj.SiaPath, cachedFile)
+				if err != nil {
+					return removed, err
+				}
+				continue
+			}
+		}
+
+		err = b.deleteObjectRecord(obj.Bucket, obj.Name)
+		if err != nil {
+			return removed, err
+		}
+		os.Remove(abs(cachedFile))
+		removed = append(removed, obj.Bucket + "/" + obj.Name)
+	}
+
+	return removed, nil
+}
+
+// Frees at least bytesToFree bytes of cache space right away, if possible, by
+// removing the cached copies of uploaded objects, least recently fetched
+// first. Objects with a PurgeAfter of 0 are always kept in the cache. Returns
+// the number of bytes actually freed.
+func (b *SiaBridge) ReclaimCache(bytesToFree int64) (freed int64, e error) {
+	if b.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	objects, err := b.listObjectsWhere("uploaded IS NOT NULL AND purge_after<>0 AND pinned=0 ORDER BY COALESCE(last_fetch,0), queued")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, obj := range objects {
+		if freed >= bytesToFree {
+			break
+		}
+
+		path := abs(b.cachePath(obj))
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return freed, err
+		}
+		freed += fi.Size()
+	}
+
+	return freed, nil
+}
+
+// Removes the cached copies of all uploaded objects in the bucket, leaving
+// them on Sia, and returns the number of bytes freed. Objects still uploading
+// and pinned objects are kept in the cache.
+func (b *SiaBridge) PurgeBucketCache(bucket string) (freed int64, e error) {
+	if b.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	objects, err := b.listObjectsWhere("bucket=? AND uploaded IS NOT NULL AND pinned=0", bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, obj := range objects {
+		path := abs(b.cachePath(obj))
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return freed, err
+		}
+		freed += fi.Size()
+	}
+
+	return freed, nil
+}
+
+// Returns up to limit operations recorded in the audit log at or after the
+// time provided, oldest first. There's no limit if limit is 0. Operations are
+// only recorded while EnableAuditLog is set.
+func (b *SiaBridge) ListEvents(since time.Time, limit int) (events []ObjectEvent, e error) {
+	query := "SELECT at, type, bucket, name, detail FROM object_events WHERE at>=? ORDER BY at"
+	args := []interface{}{toMillis(since)}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := g_db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ev ObjectEvent
+		var at int64
+		var evType string
+		err = rows.Scan(&at, &evType, &ev.Bucket, &ev.Name, &ev.Detail)
+		if err != nil {
+			return nil, err
+		}
+		ev.Time = fromMillis(at)
+		ev.Type = EventType(evType)
+		events = append(events, ev)
+	}
+
+	return events, rows.Err()
+}
+
+// Returns all objects sharing their bucket and name with another object,
+// ordered by bucket and name. The objects table's primary key prevents this,
+// but databases created before it was enforced may have such duplicates.
+func (b *SiaBridge) FindDuplicateObjects() (objects []ObjectInfo, e error) {
+	return b.listObjectsWhere("(SELECT COUNT(*) FROM objects o2 WHERE o2.bucket=objects.bucket AND o2.name=objects.name) > 1 ORDER BY bucket, name, queued")
+}
+
+// Removes duplicate objects found by FindDuplicateObjects, keeping the most
+// recently queued object of each bucket and name. Returns the number of
+// objects removed. Data on Sia belonging only to removed objects is left alone.
+func (b *SiaBridge) DeduplicateObjects() (removed int, e error) {
+	if b.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	dups, err := b.FindDuplicateObjects()
+	if err != nil {
+		return 0, err
+	}
+
+	// Pick the object to keep for each bucket and name
+	var keep []ObjectInfo
+	for _, obj := range dups {
+		last := len(keep) - 1
+		if last >= 0 && keep[last].Bucket == obj.Bucket && keep[last].Name == obj.Name {
+			if !obj.Queued.Before(keep[last].Queued) {
+				keep[last] = obj
+			}
+			continue
+		}
+		keep = append(keep, obj)
+	}
+
+	// Duplicate rows can't be told apart, so replace each set with the one kept
+	tx, err := g_db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, obj := range keep {
+		_, err = tx.Exec("DELETE FROM objects WHERE bucket=? AND name=?", obj.Bucket, obj.Name)
+		if err == nil {
+			err = b.insertObject(tx, obj)
+		}
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("DeduplicateObjects: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(dups) - len(keep), nil
+}
+
+// Removes files in the cache directories that don't belong to any object in
+// the database, such as those left behind by a crash or manual file operations.
+// Also counts the uploaded objects whose data isn't cached, which is expected
+// after a purge, and reports the objects still uploading without cached data,
+// which can never finish. Meant to be called after a restart, before any
+// uploads start, since an upload's file is cached before its object is recorded.
+func (b *SiaBridge) ReconcileCache() (result CacheReconciliation, e error) {
+	if b.ReadOnly {
+		return result, ErrReadOnly
+	}
+
+	// Collect the cache paths of all objects
+	expected := make(map[string]bool)
+	err := b.forEachObjectWhere(func(obj ObjectInfo) error {
+		path := b.cachePath(obj)
+		expected[abs(path)] = true
+
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if obj.IsUploaded() {
+				result.NotCached++
+			} else {
+				result.Missing = append(result.Missing, obj.Bucket+"/"+obj.Name)
+			}
+		}
+		return nil
+	}, "1=1")
+	if err != nil {
+		return result, err
+	}
+
+	dirs := []string{b.cacheDir()}
+	if b.HotCacheDir != "" {
+		dirs = append(dirs, b.HotCacheDir)
+	}
+
+	// The database may live in a cache directory too
+	dbFile := abs(b.DbFile)
+
+	for _, dir := range dirs {
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				// Parts of multipart uploads aren't objects yet
+				if path == filepath.Join(dirs[0], MULTIPART_DIR) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			// Leave alone temporary files in use by readers, and the database
+			if strings.HasPrefix(info.Name(), ".tmp-") {
+				return nil
+			}
+			path = abs(path)
+			if b.DbFile != "" && strings.HasPrefix(path, dbFile) {
+				return nil
+			}
+
+			if !expected[path] {
+				err = os.Remove(path)
+				if err == nil {
+					result.Removed++
+				}
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// Moves the cache directory's files to newDir, copying and removing them if
+// newDir is on another filesystem, and then makes newDir the bridge's
+// CacheDir. HotCacheDir isn't affected. Refused with ErrUploadInProgress while
+// siad is reading any object's data from CacheDir. Puts, appends and multipart
+// uploads wait until the move is done. If a file can't be moved, CacheDir is
+// left unchanged and calling again carries on where the move stopped.
+// Downloads running during the move may leave files in the old directory,
+// which can be removed afterwards.
+func (b *SiaBridge) RelocateCache(newDir string) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+
+	g_cache_mutex.Lock()
+	defer g_cache_mutex.Unlock()
+
+	uploading, err := b.listUploadingObjects()
+	if err != nil {
+		return err
+	}
+	for _, obj := range uploading {
+		if obj.CacheDir == "" {
+			return ErrUploadInProgress
+		}
+	}
+
+	// Keep the manager from purging or promoting files as they're moved
+	g_manager_mutex.Lock()
+	defer g_manager_mutex.Unlock()
+
+	err = os.MkdirAll(newDir, 0744)
+	if err != nil {
+		return cacheError(err)
+	}
+
+	// The database and hot cache may live in the cache directory too
+	oldDir := b.CacheDir
+	dbFile := abs(b.DbFile)
+	hotDir := ""
+	if b.HotCacheDir != "" {
+		hotDir = abs(b.HotCacheDir)
+	}
+
+	err = filepath.Walk(oldDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if hotDir != "" && abs(path) == hotDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Leave alone temporary files in use, and the database
+		if strings.HasPrefix(info.Name(), ".tmp-") {
+			return nil
+		}
+		if b.DbFile != "" && strings.HasPrefix(abs(path), dbFile) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(oldDir, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(newDir, rel)
+		os.MkdirAll(filepath.Dir(dst), 0744)
+		return moveFile(path, dst)
+	})
+	if err != nil {
+		return err
+	}
+
+	g_cache_dir_mutex.Lock()
+	b.CacheDir = newDir
+	g_cache_dir_mutex.Unlock()
+	return nil
+}
+
+// Pauses the cache management process. Uploads aren't marked complete and
+// nothing is purged from or promoted within the cache until ResumeManager is
+// called.
+func (b *SiaBridge) PauseManager() {
+	atomic.StoreInt32(&g_manager_paused, 1)
+}
+
+// Resumes the cache management process after PauseManager
+func (b *SiaBridge) ResumeManager() {
+	atomic.StoreInt32(&g_manager_paused, 0)
+}
+
+// Runs periodically to manage the database and cache
+func (b *SiaBridge) manager() {
+	if atomic.LoadInt32(&g_manager_paused) != 0 {
+		return
+	}
+
+	g_manager_mutex.Lock()
+	defer g_manager_mutex.Unlock()
+
+	// Check to see if any files in database have completed uploading to Sia.
+	// If so, update uploaded timestamp in database. Skipped if uploadManager
+	// is doing this on its own schedule.
+	if b.UploadCheckInterval <= 0 {
+		err := b.checkSiaUploads()
+		if err != nil {
+			b.managerError(err)
+		}
+	}
+
+	// Remove files from cache that have not been uploaded or fetched in purge_after seconds.
+	err := b.purgeCache()
+	if err != nil {
+		b.managerError(err)
+	}
+
+	// Move frequently fetched objects to the hot cache directory
+	err = b.promoteObjects()
+	if err != nil {
+		b.managerError(err)
+	}
+
+	// Check that uploaded objects are still available on Sia
+	err = b.checkAvailability()
+	if err != nil {
+		b.managerError(err)
+	}
+
+	// Delete objects whose expiry time has passed
+	err = b.expireObjects()
+	if err != nil {
+		b.managerError(err)
+	}
+
+	// Record sizes for GetStatsHistory, if it's time to
+	err = b.sampleStats()
+	if err != nil {
+		b.managerError(err)
+	}
+
+}
+
+// Checks that uploaded objects are still available on Sia, once every
+// AvailabilityCheckInterval. An object siad no longer reports available, or no
+// longer has at all, has its UnavailableSince time set and is passed to
+// OnObjectUnavailable. The time is cleared once the object is available again.
+func (b *SiaBridge) checkAvailability() error {
+	if b.AvailabilityCheckInterval <= 0 || time.Since(g_last_availability_check) < b.AvailabilityCheckInterval {
+		return nil
+	}
+	g_last_availability_check = time.Now()
+
+	objects, err := b.listObjectsWhere("uploaded IS NOT NULL")
+	if err != nil || len(objects) == 0 {
+		return err
+	}
+
+	files, err := renterFileMap(b.SiadAddress)
+	if err != nil {
+		return err
+	}
+
+	now := fromMillis(toMillis(time.Now()))
+	for _, obj := range objects {
+		// Objects marked uploaded by UploadAvailableThreshold may not be
+		// flagged available yet
+		file, ok := files[obj.SiaPath]
+		available := ok && b.uploadComplete(file)
+
+		switch {
+		case !available && obj.UnavailableSince == nil:
+			err = b.updateUnavailableSince(obj.Bucket, obj.Name, &now)
+			if err != nil {
+				return err
+			}
+			obj.UnavailableSince = &now
+			if b.OnObjectUnavailable != nil {
+				b.OnObjectUnavailable(obj)
+			}
+		case available && obj.UnavailableSince != nil:
+			err = b.updateUnavailableSince(obj.Bucket, obj.Name, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Permanently deletes every object whose ExpiresAt time has passed, from the
+// database, the cache and Sia. Objects still under retention are kept until
+// their RetainUntil time passes too.
+func (b *SiaBridge) expireObjects() error {
+	objects, err := b.listObjectsWhere("expires_at<=?", toMillis(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if object.isLocked() {
+			continue
+		}
+
+		// Wait for the upload to finish unless DeleteObject may cancel it
+		if !object.IsUploaded() && !b.CancelUploadOnDelete {
+			continue
+		}
+
+		// One object failing to delete shouldn't hold up the rest
+		err = b.DeleteObject(object.Bucket, object.Name)
+		if err != nil {
+			b.managerError(fmt.Errorf("expiring %s/%s: %w", object.Bucket, object.Name, err))
+		}
+	}
+	return nil
+}
+
+// Records the object count and sizes in stats_history once StatsInterval has
+// passed since the last sample, and deletes samples older than StatsRetention.
+// The last sample's time is read from the table, so the interval is kept
+// across restarts.
+func (b *SiaBridge) sampleStats() error {
+	if b.StatsInterval <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var last sql.NullInt64
+	err := g_db.QueryRow("SELECT MAX(at) FROM stats_history").Scan(&last)
+	if err != nil {
+		return err
+	}
+	if last.Valid && now.Sub(fromMillis(last.Int64)) < b.StatsInterval {
+		return nil
+	}
+
+	var sample StatsSample
+	err = g_db.QueryRow("SELECT COUNT(*), COALESCE(SUM(size),0) FROM objects").Scan(&sample.Objects, &sample.Bytes)
+	if err != nil {
+		return err
+	}
+
+	scanned := 0
+	err = b.forEachObjectWhere(func(obj ObjectInfo) error {
+		b.PurgePolicy.throttleScan(&scanned)
+		if fi, err := os.Stat(abs(b.cachePath(obj))); err == nil {
+			sample.CacheBytes += fi.Size()
+		}
+		return nil
+	}, "1=1")
+	if err != nil {
+		return err
+	}
+
+	_, err = g_db.Exec("INSERT INTO stats_history(at, objects, bytes, cache_bytes) values(?,?,?,?)", toMillis(now), sample.Objects, sample.Bytes, sample.CacheBytes)
+	if err != nil {
+		return fmt.Errorf("sampleStats: %w", err)
+	}
+
+	if b.StatsRetention > 0 {
+		_, err = g_db.Exec("DELETE FROM stats_history WHERE at<?", toMillis(now.Add(-b.StatsRetention)))
+		if err != nil {
+			return fmt.Errorf("sampleStats: %w", err)
+		}
+	}
+	return nil
+}
+
+// Logs an error hit by the cache management process, and passes it to
+// OnManagerError if set
+func (b *SiaBridge) managerError(err error) {
+	fmt.Println("Error in DB/Cache Management Process:")
+	fmt.Println(err)
+	if b.OnManagerError != nil {
+		b.OnManagerError(err)
+	}
+}
+
+// Runs every UploadCheckInterval, if set, to mark completed uploads
+func (b *SiaBridge) uploadManager() {
+	if atomic.LoadInt32(&g_manager_paused) != 0 {
+		return
+	}
+
+	g_manager_mutex.Lock()
+	defer g_manager_mutex.Unlock()
+
+	err := b.checkSiaUploads()
+	if err != nil {
+		b.managerError(err)
+	}
+}
+
+func (b *SiaBridge) purgeCache() error {
+	if b.ShouldPurge != nil {
+		return b.purgeCacheWith(b.ShouldPurge)
+	}
+
+	buckets, err := b.ListBuckets()
+	if err != nil {
+		return err
+	}
+
+	scanned := 0
+	for _, bucket := range buckets {
+		objects, err := b.ListObjects(bucket.Name)
+		if err != nil {
+			return err
+		}
+
+		for _, object := range objects {
+			b.PurgePolicy.throttleScan(&scanned)
+
+			if object.IsUploaded() && !object.Pinned {
+				purge_after := b.PurgePolicy.purgeAfter(object)
+				since_uploaded := time.Now().Unix() - object.Uploaded.Unix()
+				stale := since_uploaded > purge_after
+
+				// Objects never fetched (a nil LastFetch) only have to wait
+				// out the upload window
+				if object.LastFetch != nil {
+					since_fetched := time.Now().Unix() - object.LastFetch.Unix()
+					stale = stale && since_fetched > purge_after
+				}
+
+				if stale {
+					os.Remove(abs(b.cachePath(object)))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Removes each cached, uploaded object from the cache for which shouldPurge
+// returns true. cacheSizeUsed is the total size in bytes of the cached objects,
+// and goes down as objects are purged.
+func (b *SiaBridge) purgeCacheWith(shouldPurge func(ObjectInfo, int64) bool) error {
+	all, err := b.listObjectsWhere("1=1")
+	if err != nil {
+		return err
+	}
+
+	var objects []ObjectInfo
+	var sizes []int64
+	var used int64
+	scanned := 0
+	for _, object := range all {
+		b.PurgePolicy.throttleScan(&scanned)
+
+		if fi, err := os.Stat(abs(b.cachePath(object))); err == nil {
+			objects = append(objects, object)
+			sizes = append(sizes, fi.Size())
+			used += fi.Size()
+		}
+	}
+
+	for i, object := range objects {
+		// Siad may still be reading the cache file of an uploading object
+		if !object.IsUploaded() || object.Pinned || !shouldPurge(object, used) {
+			continue
+		}
+
+		if os.Remove(abs(b.cachePath(object))) == nil {
+			used -= sizes[i]
+		}
+	}
+	return nil
+}
+
+// Returns the number of seconds the object should stay in the cache under
+// the policy
+func (p PurgePolicy) purgeAfter(object ObjectInfo) int64 {
+	fetches := object.CachedFetches + object.SiaFetches
+
+	if fetches == 0 && p.UnfetchedPurgeAfter > 0 && p.UnfetchedPurgeAfter < object.PurgeAfter {
+		return p.UnfetchedPurgeAfter
+	}
+	if p.FrequentFetches > 0 && fetches >= p.FrequentFetches && p.FrequentPurgeAfter > object.PurgeAfter {
+		return p.FrequentPurgeAfter
+	}
+	return object.PurgeAfter
+}
+
+// Counts an object checked by a cache scan, and pauses the scan for ScanPause
+// after every ScanBatchSize objects so it doesn't starve foreground requests
+// of disk I/O
+func (p PurgePolicy) throttleScan(scanned *int) {
+	*scanned += 1
+	if p.ScanBatchSize > 0 && p.ScanPause > 0 && *scanned%p.ScanBatchSize == 0 {
+		time.Sleep(p.ScanPause)
+	}
+}
+
+// Downloads the n most fetched uploaded objects into the cache, skipping any
+// already cached. A download that fails is passed to managerError and the rest
+// carry on.
+func (b *SiaBridge) warmCache(n int) error {
+	objects, err := b.listObjectsWhere("uploaded IS NOT NULL ORDER BY cached_fetches + sia_fetches DESC LIMIT ?", n)
 	if err != nil {
 		return err
 	}
 
+	for _, object := range objects {
+		err = b.warmObject(object)
+		if err != nil {
+			b.managerError(fmt.Errorf("warmCache: %s/%s: %w", object.Bucket, object.Name, err))
+		}
+	}
 	return nil
 }
 
-// Uploads the data from the file specified to the bucket and object name specified
-func (b *SiaBridge) PutObjectFromFile(file string, bucket string, objectName string, purge_after int64) error {
-	// Make sure file exists and get size in bytes
-	fi, err := os.Stat(file);
+// Downloads the object into the cache unless it's already cached
+func (b *SiaBridge) warmObject(object ObjectInfo) error {
+	cachedFile := b.cachePath(object)
+	if _, err := os.Stat(cachedFile); err == nil {
+		return nil
+	}
+	return b.downloadToCache(object, cachedFile)
+}
+
+func (b *SiaBridge) promoteObjects() error {
+	if b.HotCacheDir == "" || b.PromoteFetches <= 0 {
+		return nil
+	}
+
+	objects, err := b.listObjectsWhere("cached_fetches>=? AND cache_dir<>?", b.PromoteFetches, b.HotCacheDir)
 	if err != nil {
-	    return err
+		return err
 	}
-	size := fi.Size()
 
-	// Get a reader to the file
-	data, err := os.Open(file)
+	for _, object := range objects {
+		// Siad may still be reading the cache file of an uploading object
+		if !object.IsUploaded() {
+			continue
+		}
+
+		// Only objects currently in the cache can be promoted
+		src := b.cachePath(object)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+
+		object.CacheDir = b.HotCacheDir
+		dst := b.cachePath(object)
+		os.MkdirAll(filepath.Dir(dst), 0744)
+
+		err = moveFile(src, dst)
+		if err != nil {
+			return err
+		}
+
+		err = b.updateCacheDir(object.Bucket, object.Name, b.HotCacheDir)
+		if err != nil {
+			// Put the file back where the database says it is
+			moveFile(dst, src)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the bridge's CacheDir, which RelocateCache may change at any time
+func (b *SiaBridge) cacheDir() string {
+	g_cache_dir_mutex.Lock()
+	defer g_cache_dir_mutex.Unlock()
+	return b.CacheDir
+}
+
+// Returns the path of the object's file in the local cache, which mirrors the
+// object's Sia path
+func (b *SiaBridge) cachePath(obj ObjectInfo) string {
+	dir := obj.CacheDir
+	if dir == "" {
+		dir = b.cacheDir()
+	}
+	return filepath.Join(dir, filepath.FromSlash(obj.SiaPath))
+}
+
+// Returns an AES-GCM cipher using the bridge's EncryptionKey
+func (b *SiaBridge) newAEAD() (cipher.AEAD, error) {
+	if len(b.EncryptionKey) == 0 {
+		return nil, ErrNoEncryptionKey
+	}
+	return newAEAD(b.EncryptionKey)
+}
+
+// Returns whether an object's data must be decoded before it's served
+func isEncoded(objInfo ObjectInfo) bool {
+	return objInfo.Compressed || objInfo.Nonce != nil
+}
+
+// Opens an object's data in the cache file at path for reading, undoing any
+// compression and encryption applied when it was uploaded
+func (b *SiaBridge) openObjectData(objInfo ObjectInfo, path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isEncoded(objInfo) {
+		return file, nil
+	}
+
+	var reader io.Reader = file
+	if objInfo.Nonce != nil {
+		aead, err := b.newAEAD()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		reader = newDecryptReader(reader, aead, objInfo.Nonce)
+	}
+	if objInfo.Compressed {
+		reader, err = gzip.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &decodedReader{Reader: reader, file: file}, nil
+}
+
+// Opens an object's data in the cache file at path for random access. Data
+// that must be decoded is decoded into a temporary file removed on Close.
+func (b *SiaBridge) openObjectFile(objInfo ObjectInfo, path string) (ReadSeekCloser, error) {
+	if !isEncoded(objInfo) {
+		return os.Open(path)
+	}
+
+	reader, err := b.openObjectData(objInfo, path)
 	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return newTempFile(reader, b.cacheDir())
+}
+
+// Returns the cache directory holding the parts of a multipart upload
+func (b *SiaBridge) multipartDir(uploadID string) string {
+	return filepath.Join(b.cacheDir(), MULTIPART_DIR, uploadID)
+}
+
+// Returns the cache file holding a part of a multipart upload
+func (b *SiaBridge) partPath(uploadID string, partNumber int) string {
+	return filepath.Join(b.multipartDir(uploadID), strconv.Itoa(partNumber))
+}
+
+// Returns the Sia path for a new object, which is also its path within the
+// cache. The bucket and object name are each escaped so that names containing
+// slashes stay a single path element. Otherwise object "foo/bar" in bucket "b"
+// and object "bar" in bucket "b/foo" would share a path, and the bucket and
+// object name couldn't be recovered from the Sia path unambiguously. Objects
+// uploaded before escaping was introduced keep their recorded Sia path.
+func siaPath(bucket string, objectName string) string {
+	return url.PathEscape(bucket) + "/" + url.PathEscape(objectName)
+}
+
+// Returns ErrInvalidName for an object name that siaPath can't turn into a path
+// element of its own. Escaping leaves "." and ".." as they are, which would
+// make the object's path its bucket's directory or the directory above it.
+func checkObjectName(objectName string) error {
+	if objectName == "" || objectName == "." || objectName == ".." {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// Returns ErrInvalidName for a bucket name checkObjectName would reject, or
+// for MULTIPART_DIR, whose objects would share the cache directory of parts
+// of multipart uploads
+func checkBucketName(bucket string) error {
+	if bucket == MULTIPART_DIR {
+		return ErrInvalidName
+	}
+	return checkObjectName(bucket)
+}
+
+func (b *SiaBridge) checkSiaUploads() error {
+	// Get list of all uploading objects
+	objs, err := b.listUploadingObjects()
+	if err != nil || len(objs) == 0 {
 		return err
 	}
 
-	err = b.PutObjectFromReader(data, bucket, objectName, size, purge_after)
-	data.Close()
+	// Get the renter files of the uploading objects, keyed by siapath. A few
+	// are queried individually, falling back to the full list for siad
+	// versions without the single file call.
+	var files map[string]modules.FileInfo
+	if len(objs) <= FILE_QUERY_MAX_UPLOADS {
+		siapaths := make([]string, len(objs))
+		for i, obj := range objs {
+			siapaths[i] = obj.SiaPath
+		}
+		if found, ferr := renterFilesFor(b.SiadAddress, siapaths); ferr == nil {
+			files = found
+		}
+	}
+	if files == nil {
+		files, err = renterFileMap(b.SiadAddress)
+		if err != nil {
+			return err
+		}
+	}
+
+	// If uploading object is available on Sia, update database. A file of the
+	// wrong size is an older version still waiting to be replaced, e.g. by
+	// AppendToObject.
+	var completed []ObjectInfo
+	for _, obj := range objs {
+		file, ok := files[obj.SiaPath]
+		if !ok || !b.uploadComplete(file) {
+			continue
+		}
+		if obj.StoredSize > 0 && int64(file.Filesize) != obj.StoredSize {
+			continue
+		}
+		completed = append(completed, obj)
+	}
+
+	return b.markObjectsUploaded(completed)
+}
+
+// Sends siad's upload progress for the object to events, if not nil, until the
+// upload completes, marking the object uploaded if the manager hasn't yet.
+// Returns ErrUploadTimeout once the deadline passes, unless it's zero, or
+// ErrBridgeStopped if the bridge is stopped first.
+func (b *SiaBridge) watchUpload(objInfo ObjectInfo, events chan<- UploadEvent, deadline time.Time) error {
+	ticker := time.NewTicker(time.Second * UPLOAD_POLL_SEC)
+	defer ticker.Stop()
+
+	// Never closed if the bridge wasn't started
+	stop := g_stop
+
+	progress := -1.0
+	for {
+		// The object may have been deleted, or marked uploaded by the manager
+		obj, err := b.GetObjectInfo(objInfo.Bucket, objInfo.Name)
+		if err != nil {
+			return err
+		}
+		if obj.IsUploaded() {
+			return nil
+		}
+
+		var rf api.RenterFiles
+		err = getAPI(b.SiadAddress, "/renter/files", &rf)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range rf.Files {
+			if file.SiaPath != obj.SiaPath {
+				continue
+			}
+			if events != nil && file.UploadProgress != progress {
+				progress = file.UploadProgress
+				events <- UploadEvent{Type: UploadProgress, Progress: progress}
+			}
+			if b.uploadComplete(file) {
+				return b.markObjectUploaded(obj.Bucket, obj.Name)
+			}
+		}
+
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return ErrUploadTimeout
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return ErrBridgeStopped
+		}
+	}
+}
+
+// Returns whether a renter file has uploaded far enough to be marked uploaded.
+// Siad's upload progress can reach the threshold a little before it reports
+// the file as available, but an object marked uploaded by progress alone may
+// not yet have the redundancy siad considers safe. Once marked, the object can
+// be purged from the cache and served only from Sia.
+func (b *SiaBridge) uploadComplete(file modules.FileInfo) bool {
+	if file.Available {
+		return true
+	}
+	return b.UploadAvailableThreshold > 0 && file.UploadProgress >= b.UploadAvailableThreshold
+}
+
+// Tells the Sia daemon to rename a file
+func (b *SiaBridge) renameSiaFile(oldPath string, newPath string) error {
+	return post(b.SiadAddress, "/renter/rename/"+escapeSiaPath(oldPath), url.Values{"newsiapath": {newPath}}.Encode())
+}
+
+// Tells the Sia daemon to upload the source file to the Sia path provided
+func (b *SiaBridge) startSiaUpload(siaObj string, source string) error {
+	err := post(b.SiadAddress, "/renter/upload/"+escapeSiaPath(siaObj), url.Values{"source": {abs(source)}}.Encode())
+	if isFileExistsError(err) {
+		err = ErrSiaFileExists
+	}
+	if err != nil {
+		return fmt.Errorf("startSiaUpload %s: %w", siaObj, b.checkWallet(err))
+	}
+	return nil
+}
+
+// Returns the estimated Siacoin cost of storing bytes on Sia for the duration
+// provided, using siad's price per terabyte per month. Siad's estimate
+// includes the redundancy it uploads files with.
+func (b *SiaBridge) storageCost(bytes int64, duration time.Duration) (string, error) {
+	var rp api.RenterPricesGET
+	err := getAPI(b.SiadAddress, "/renter/prices", &rp)
+	if err != nil {
+		return "", err
+	}
+
+	// Sia's month is 4320 blocks of about 10 minutes each
+	const month = 4320 * 10 * time.Minute
+
+	cost := rp.StorageTerabyteMonth.Big()
+	cost.Mul(cost, big.NewInt(bytes))
+	cost.Mul(cost, big.NewInt(int64(duration/time.Second)))
+	cost.Div(cost, new(big.Int).Mul(big.NewInt(1e12), big.NewInt(int64(month/time.Second))))
+	return types.NewCurrency(cost).HumanString(), nil
+}
+
+// Returns whether siad's wallet is unlocked
+func (b *SiaBridge) walletUnlocked() (bool, error) {
+	var wg api.WalletGET
+	err := getAPI(b.SiadAddress, "/wallet", &wg)
+	if err != nil {
+		return false, err
+	}
+	return wg.Unlocked, nil
+}
+
+// Returns ErrWalletLocked in place of a siad error if siad's wallet is locked,
+// as that's the likely cause and siad's own message doesn't say so
+func (b *SiaBridge) checkWallet(err error) error {
+	var siadErr *SiadError
+	if !errors.As(err, &siadErr) {
+		return err
+	}
+	if unlocked, werr := b.walletUnlocked(); werr == nil && !unlocked {
+		return ErrWalletLocked
+	}
 	return err
 }
 
-// Deletes the object
-func (b *SiaBridge) DeleteObject(bucket string, objectName string) error {
-	// Delete record from database
-	stmt, err := g_db.Prepare("DELETE FROM objects WHERE bucket=? AND name=?")
+// If VerifyCacheSize is set, checks that the object's cache file, if any, has
+// the size recorded for the object. A wrong-sized copy of an uploaded object is
+// removed so it's fetched from Sia again, and reported to managerError. For an
+// object that hasn't finished uploading, the copy is the only one, so
+// ErrCacheSizeMismatch is returned.
+func (b *SiaBridge) checkCacheSize(objInfo ObjectInfo, cachedFile string) error {
+	if !b.VerifyCacheSize {
+		return nil
+	}
+
+	fi, err := os.Stat(cachedFile)
+	if err != nil || fi.Size() == objInfo.StoredSize {
+		return nil
+	}
+
+	if !objInfo.IsUploaded() {
+		return ErrCacheSizeMismatch
+	}
+	b.managerError(fmt.Errorf("checkCacheSize: %s/%s is %d bytes, expected %d: %w", objInfo.Bucket, objInfo.Name, fi.Size(), objInfo.StoredSize, ErrCacheSizeMismatch))
+	return os.Remove(abs(cachedFile))
+}
+
+// Downloads the object from Sia into the cache file provided, and returns the
+// path of the file to serve. If the download fails and ServeStaleOnSiaError is
+// set, a copy of the object left in another cache directory (for example, one
+// whose move between cache tiers was interrupted) is served instead, and the
+// download's error is passed to managerError.
+func (b *SiaBridge) fetchFromSia(objInfo ObjectInfo, cachedFile string) (string, error) {
+	err := b.downloadToCache(objInfo, cachedFile)
+	if err == nil || !b.ServeStaleOnSiaError {
+		return cachedFile, err
+	}
+
+	for _, dir := range []string{b.cacheDir(), b.HotCacheDir} {
+		if dir == "" {
+			continue
+		}
+
+		obj := objInfo
+		obj.CacheDir = dir
+		stale := b.cachePath(obj)
+		if stale == cachedFile {
+			continue
+		}
+
+		if _, serr := os.Stat(stale); serr == nil {
+			b.managerError(fmt.Errorf("fetchFromSia: serving stale copy of %s/%s from %s: %w", objInfo.Bucket, objInfo.Name, stale, err))
+			return stale, nil
+		}
+	}
+
+	return cachedFile, err
+}
+
+// Downloads the object from Sia into the cache file provided, giving up after
+// DownloadTimeout. Siad can't be told to abandon a download, so one that times
+// out may still finish in the background. Each attempt downloads to a new
+// temporary file beside the cache file, which is verified if VerifyDownloads
+// is set and then renamed into place, so an incomplete or corrupt download is
+// never served, even when the object is being downloaded more than once.
+func (b *SiaBridge) downloadToCache(objInfo ObjectInfo, cachedFile string) error {
+	// Make sure the file was completely uploaded to Sia
+	if !objInfo.IsUploaded() {
+		// File never completed uploaded, or was never marked as uploaded in
+		// database. CheckObjectAvailable tells whether it ever will be.
+		return ErrObjectNotYetAvailable
+	}
+
+	// Fail fast if the object recently failed to download
+	if err := b.recentDownloadFailure(objInfo.SiaPath); err != nil {
+		return err
+	}
+
+	// Make sure bucket path exists in cache directory
+	os.MkdirAll(filepath.Dir(cachedFile), 0744)
+
+	delay := time.Second * DOWNLOAD_RETRY_DELAY_SEC
+	var err error
+	for attempt := 0; ; attempt++ {
+		var staged string
+		staged, err = newStagingFile(filepath.Dir(cachedFile))
+		if err != nil {
+			break
+		}
+
+		err = b.downloadWithTimeout(objInfo, staged)
+		if err == nil && b.VerifyDownloads {
+			err = b.verifyDownload(objInfo, staged)
+		}
+		if err == nil {
+			err = cacheError(os.Rename(abs(staged), abs(cachedFile)))
+		}
+
+		// Siad may still be writing a timed out download, so its file is
+		// left for downloadWithTimeout to remove and it isn't retried
+		if err == nil || err == ErrDownloadTimeout {
+			break
+		}
+		os.Remove(abs(staged))
+
+		// Retrying can't help when the cache can't be written
+		if attempt >= b.DownloadRetries || err == ErrCacheReadOnly || err == ErrCacheFull {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	b.recordDownloadResult(objInfo.SiaPath, err)
+	return err
+}
+
+// Returns ErrDownloadCorrupt if the object's data downloaded to path has the
+// wrong size, or is encrypted or compressed and doesn't decode
+func (b *SiaBridge) verifyDownload(objInfo ObjectInfo, path string) error {
+	fi, err := os.Stat(abs(path))
+	if err != nil {
+		return err
+	}
+	if objInfo.StoredSize > 0 && fi.Size() != objInfo.StoredSize {
+		return ErrDownloadCorrupt
+	}
+	if !isEncoded(objInfo) {
+		return nil
+	}
+
+	// Decryption authenticates the data, and gzip checks its CRC at the end
+	data, err := b.openObjectData(objInfo, abs(path))
+	if err != nil {
+		return ErrDownloadCorrupt
+	}
+	defer data.Close()
+
+	_, err = io.Copy(ioutil.Discard, data)
+	if err != nil {
+		return ErrDownloadCorrupt
+	}
+	return nil
+}
+
+// Performs the Sia download for downloadToCache, giving up after
+// DownloadTimeout. A download that times out keeps running in the background,
+// since siad may still be writing its file, and the file is removed once it
+// finishes.
+func (b *SiaBridge) downloadWithTimeout(objInfo ObjectInfo, cachedFile string) error {
+	if b.DownloadTimeout <= 0 {
+		return b.downloadFromSia(objInfo, cachedFile)
+	}
+
+	done := make(chan error)
+	abandoned := make(chan struct{})
+	go func() {
+		err := b.downloadFromSia(objInfo, cachedFile)
+		select {
+		case done <- err:
+		case <-abandoned:
+			os.Remove(abs(cachedFile))
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(b.DownloadTimeout):
+		close(abandoned)
+		return ErrDownloadTimeout
+	}
+}
+
+// Returns the error a Sia download of the siapath failed with, if it failed
+// within the last DownloadFailureTTL
+func (b *SiaBridge) recentDownloadFailure(siaPath string) error {
+	if b.DownloadFailureTTL <= 0 {
+		return nil
+	}
+
+	g_failed_mutex.Lock()
+	defer g_failed_mutex.Unlock()
+
+	failure, ok := g_failed_downloads[siaPath]
+	if !ok {
+		return nil
+	}
+	if time.Since(failure.at) > b.DownloadFailureTTL {
+		delete(g_failed_downloads, siaPath)
+		return nil
+	}
+	return failure.err
+}
+
+// Remembers a failed Sia download of the siapath, or forgets any earlier
+// failure if the download succeeded
+func (b *SiaBridge) recordDownloadResult(siaPath string, err error) {
+	if b.DownloadFailureTTL <= 0 {
+		return
+	}
+
+	g_failed_mutex.Lock()
+	defer g_failed_mutex.Unlock()
+
+	if err != nil {
+		g_failed_downloads[siaPath] = downloadFailure{err: err, at: time.Now()}
+	} else {
+		delete(g_failed_downloads, siaPath)
+	}
+}
+
+// Performs the Sia download for downloadToCache
+func (b *SiaBridge) downloadFromSia(objInfo ObjectInfo, cachedFile string) error {
+	// Make sure bucket path exists in cache directory
+	os.MkdirAll(filepath.Dir(cachedFile), 0744)
+
+	// Siad writes downloads straight to disk, so when the rate is limited the
+	// data has to be streamed through the bridge instead.
+	if b.DownloadRateLimit > 0 {
+		err := b.streamToCache(objInfo, cachedFile)
+		if err != nil {
+			return fmt.Errorf("downloadFromSia %s: %w", objInfo.SiaPath, b.checkWallet(err))
+		}
+		return nil
+	}
+
+	// Don't leave a partial download behind to be served as a cached copy
+	query := url.Values{"destination": {abs(cachedFile)}}
+	err := get(b.SiadAddress, "/renter/download/" + escapeSiaPath(objInfo.SiaPath) + "?" + query.Encode())
+	if err != nil {
+		os.Remove(abs(cachedFile))
+		return fmt.Errorf("downloadFromSia %s: %w", objInfo.SiaPath, b.checkWallet(err))
+	}
+
+	return nil
+}
+
+// Downloads the object from Sia by streaming it through the bridge into the
+// cache file provided, honoring DownloadRateLimit
+func (b *SiaBridge) streamToCache(objInfo ObjectInfo, cachedFile string) error {
+	resp, err := apiGet(b.SiadAddress, "/renter/download/" + escapeSiaPath(objInfo.SiaPath) + "?httpresp=true")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var data io.Reader = resp.Body
+	if b.DownloadRateLimit > 0 {
+		data = newRateLimitedReader(data, b.DownloadRateLimit)
+	}
+
+	err = writeFile(data, abs(cachedFile))
+	if err != nil {
+		os.Remove(abs(cachedFile))
+		return err
+	}
+
+	return nil
+}
+
+// Marks all the objects provided uploaded in a single transaction
+func (b *SiaBridge) markObjectsUploaded(objs []ObjectInfo) error {
+	if len(objs) == 0 {
+		return nil
+	}
+
+	tx, err := g_db.Begin()
+	if err != nil {
+		return fmt.Errorf("markObjectsUploaded: %w", err)
+	}
+
+	now := toMillis(time.Now())
+	for _, obj := range objs {
+		_, err = tx.Exec("UPDATE objects SET uploaded=?, modified=? WHERE bucket=? AND name=?", now, now, obj.Bucket, obj.Name)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("markObjectsUploaded: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (b *SiaBridge) markObjectUploaded(bucket string, objectName string) error {
+	stmt, err := g_db.Prepare("UPDATE objects SET uploaded=?, modified=? WHERE bucket=? AND name=?")
     if err != nil {
-    	return err
+    	return fmt.Errorf("markObjectUploaded: %w", err)
     }
-	_, err = stmt.Exec(bucket, objectName)
+
+    now := toMillis(time.Now())
+    _, err = stmt.Exec(now, now, bucket, objectName)
     if err != nil {
-    	return err
+    	return fmt.Errorf("markObjectUploaded: %w", err)
     }
 
-    // Tell Sia daemon to delete the object
-	var siaObj = bucket + "/" + objectName
-	
-	err = post(b.SiadAddress, "/renter/delete/"+siaObj, "")
+    return nil
+}
+
+// Clears the object's uploaded time, so the manager checks on its upload again
+func (b *SiaBridge) markObjectNotUploaded(bucket string, objectName string) error {
+	_, err := g_db.Exec("UPDATE objects SET uploaded=NULL, modified=? WHERE bucket=? AND name=?", toMillis(time.Now()), bucket, objectName)
 	if err != nil {
-		return err
+		return fmt.Errorf("markObjectNotUploaded: %w", err)
 	}
-
 	return nil
 }
 
-// Runs periodically to manage the database and cache
-func (b *SiaBridge) manager() {
-	// Check to see if any files in database have completed uploading to Sia.
-	// If so, update uploaded timestamp in database.
-	err := b.checkSiaUploads()
-	if err != nil {
-		fmt.Println("Error in DB/Cache Management Process:")
-		fmt.Println(err)
+func (b *SiaBridge) initDatabase() error {
+	// Open the database. Drivers other than sqlite3 must be imported by the
+	// application.
+	driver := b.DbDriver
+	if driver == "" {
+		driver = "sqlite3"
+	}
+	dsn := b.DbDSN
+	if dsn == "" {
+		dsn = b.DbFile
+		if b.ReadOnly && driver == "sqlite3" && !isMemoryDatabase(b.DbFile) {
+			dsn = readOnlyDSN(b.DbFile)
+		}
 	}
 
-	// Remove files from cache that have not been uploaded or fetched in purge_after seconds.
-	err = b.purgeCache()
+	// Only an existing database has anything worth backing up
+	var backup func() error
+	if b.BackupBeforeMigrate && driver == "sqlite3" && b.DbDSN == "" && !isMemoryDatabase(b.DbFile) {
+		if _, err := os.Stat(b.DbFile); err == nil {
+			backup = b.backupDatabase
+		}
+	}
+
+	conn, e := sql.Open(driver, dsn)
+	if e != nil {
+		return fmt.Errorf("initDatabase: %w", e)
+	}
+	dialect := sqlDialect(driver)
+	g_db = &database{DB: conn, numbered: dialect == "postgres"}
+
+	// Tune the connection pool. SQLite works well with database/sql's defaults.
+	if b.DbMaxOpenConns > 0 {
+		conn.SetMaxOpenConns(b.DbMaxOpenConns)
+	}
+	if b.DbMaxIdleConns > 0 {
+		conn.SetMaxIdleConns(b.DbMaxIdleConns)
+	}
+	conn.SetConnMaxLifetime(b.DbConnMaxLifetime)
+
+	// A read-only database can't be created or migrated, so it must
+	// already be up to date
+	if b.ReadOnly {
+		return checkSchemaCurrent()
+	}
+
+	// Make sure buckets and objects tables exist
+	for _, ddl := range baseSchemas[dialect] {
+		stmt, err := g_db.Prepare(ddl)
+	    if err != nil {
+	    	return fmt.Errorf("initDatabase: %w", err)
+	    }
+		_, err = stmt.Exec()
+	    if err != nil {
+	    	return fmt.Errorf("initDatabase: %w", err)
+	    }
+	}
+
+	// Apply any schema changes made since the tables were created
+	err := migrateDatabase(dialect, backup)
 	if err != nil {
-		fmt.Println("Error in DB/Cache Management Process:")
-		fmt.Println(err)
+		return err
 	}
 
+	return assignObjectIDs()
 }
 
-func (b *SiaBridge) purgeCache() error {
-	buckets, err := b.ListBuckets()
+// Gives an ID to each object created before objects had IDs
+func assignObjectIDs() error {
+	rows, err := g_db.Query("SELECT bucket, name FROM objects WHERE id=''")
 	if err != nil {
-		return err
+		return fmt.Errorf("assignObjectIDs: %w", err)
 	}
 
-	for _, bucket := range buckets {
-		objects, err := b.ListObjects(bucket.Name)
+	var keys [][2]string
+	for rows.Next() {
+		var key [2]string
+		err = rows.Scan(&key[0], &key[1])
 		if err != nil {
-			return err
+			rows.Close()
+			return fmt.Errorf("assignObjectIDs: %w", err)
 		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("assignObjectIDs: %w", err)
+	}
 
-		for _, object := range objects {
-			if object.Uploaded != time.Unix(0,0) {
-				since_uploaded := time.Now().Unix() - object.Uploaded.Unix()
-				since_fetched := time.Now().Unix() - object.LastFetch.Unix()
-				if since_uploaded > object.PurgeAfter && since_fetched > object.PurgeAfter {
-					var siaObj = object.Bucket + "/" + object.Name
-					var cachedFile = filepath.Join(b.CacheDir,siaObj)
-					os.Remove(abs(cachedFile))
-				}
-			}
+	for _, key := range keys {
+		id, err := newRandomID()
+		if err != nil {
+			return err
+		}
+		_, err = g_db.Exec("UPDATE objects SET id=? WHERE bucket=? AND name=? AND id=''", id, key[0], key[1])
+		if err != nil {
+			return fmt.Errorf("assignObjectIDs: %w", err)
 		}
 	}
 	return nil
 }
 
-func (b *SiaBridge) checkSiaUploads() error {
-	// Get list of all uploading objects
-	objs, err := b.listUploadingObjects()
+// Copies DbFile to DbFile.bak-<timestamp>, so a failed migration can be rolled
+// back by restoring the copy
+func (b *SiaBridge) backupDatabase() error {
+	src, err := os.Open(b.DbFile)
 	if err != nil {
 		return err
 	}
+	defer src.Close()
 
-	// Get list of all renter files
-	var rf api.RenterFiles
-	err = getAPI(b.SiadAddress, "/renter/files", &rf)
+	dst := b.DbFile + ".bak-" + time.Now().Format("20060102150405")
+	return copyFile(src, dst)
+}
+
+// Returns ErrQuotaExceeded or ErrObjectQuotaExceeded if adding an object of
+// size bytes to the bucket would take it over its quota. The check only holds
+// until another object is added unless q is a transaction that has called
+// lockBucket.
+func checkQuota(q queryer, bucket string, size int64) error {
+	var quota int64
+	var max_objects int64
+	err := q.QueryRow("SELECT quota_bytes, max_objects FROM buckets WHERE name=?", bucket).Scan(&quota, &max_objects)
+	if err == sql.ErrNoRows || (err == nil && quota <= 0 && max_objects <= 0) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	// If uploading object is available on Sia, update database
-	for _, obj := range objs {
-		var siaObj = obj.Bucket + "/" + obj.Name
-		for _, file := range rf.Files {
-			if file.SiaPath == siaObj && file.Available {
-				err = b.markObjectUploaded(obj.Bucket, obj.Name)
-				if err != nil {
-					return err
-				}
-			}
-		}
+	var count int64
+	var used int64
+	err = q.QueryRow("SELECT COUNT(*), COALESCE(SUM(size),0) FROM objects WHERE bucket=?", bucket).Scan(&count, &used)
+	if err != nil {
+		return err
+	}
+	if max_objects > 0 && count+1 > max_objects {
+		return ErrObjectQuotaExceeded
+	}
+	if quota > 0 && size > 0 && used+size > quota {
+		return ErrQuotaExceeded
 	}
-
 	return nil
 }
 
-func (b *SiaBridge) markObjectUploaded(bucket string, objectName string) error {
-	stmt, err := g_db.Prepare("UPDATE objects SET uploaded=? WHERE bucket=? AND name=?")
-    if err != nil {
-    	return err
-    }
-
-    _, err = stmt.Exec(time.Now().Unix(), bucket, objectName)
-    if err != nil {
-    	return err
-    }
-
-    return nil
+// Locks the bucket's row until the transaction ends, so that concurrent
+// changes to the bucket's objects check its quota one at a time
+func lockBucket(ex execer, bucket string) error {
+	_, err := ex.Exec("UPDATE buckets SET quota_bytes=quota_bytes WHERE name=?", bucket)
+	return err
 }
 
-func (b *SiaBridge) initDatabase() error {
-	// Open the database
-	var e error
-	g_db, e = sql.Open("sqlite3", b.DbFile)
-	if e != nil {
-		return e
+// Records a new object, unless it would take its bucket over its quota, in
+// which case ErrQuotaExceeded or ErrObjectQuotaExceeded is returned
+func (b *SiaBridge) insertObjectWithinQuota(obj ObjectInfo) error {
+	tx, err := g_db.Begin()
+	if err != nil {
+		return err
 	}
 
-	// Make sure buckets table exists
-	stmt, err := g_db.Prepare("CREATE TABLE IF NOT EXISTS buckets(name TEXT PRIMARY KEY, created INTEGER)")
-    if err != nil {
-    	return err
-    }
-	_, err = stmt.Exec()
-    if err != nil {
-    	return err
-    }
+	err = lockBucket(tx, obj.Bucket)
+	if err == nil {
+		err = checkQuota(tx, obj.Bucket, obj.Size)
+	}
+	if err == nil {
+		err = b.insertObject(tx, obj)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	// Make sure objects table exists
-    stmt, err = g_db.Prepare("CREATE TABLE IF NOT EXISTS objects(bucket TEXT, name TEXT, size INTEGER, queued INTEGER, uploaded INTEGER, purge_after INTEGER, cached_fetches INTEGER, sia_fetches INTEGER, last_fetch INTEGER, PRIMARY KEY(bucket,name) )")
-    if err != nil {
-    	return err
-    }
-	_, err = stmt.Exec()
-    if err != nil {
-    	return err
-    }
+	return tx.Commit()
+}
+
+// Returns ErrBucketNotFound if the bucket doesn't exist, unless
+// AutoCreateBucket is set, in which case the bucket is created
+func (b *SiaBridge) ensureBucket(bucket string) error {
+	if b.AutoCreateBucket {
+		return b.CreateBucket(bucket)
+	}
 
+	exists, err := b.bucketExists(bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrBucketNotFound
+	}
 	return nil
 }
 
@@ -536,105 +4238,394 @@ func (b *SiaBridge) objectExists(bucket string, objectName string) (exists bool,
 	return false, errors.New("Unknown error in objectExists()")
 }
 
-func (b *SiaBridge) updateCachedFetches(bucket string, objectName string, fetches int64) error {
-	stmt, err := g_db.Prepare("UPDATE objects SET cached_fetches=? WHERE bucket=? AND name=?")
+// Returns the bucket and object name of a multipart upload
+func (b *SiaBridge) getMultipartUpload(uploadID string) (bucket string, objectName string, e error) {
+	err := g_db.QueryRow("SELECT bucket, name FROM multipart_uploads WHERE id=?", uploadID).Scan(&bucket, &objectName)
+	if err == sql.ErrNoRows {
+		return "", "", ErrNoSuchUpload
+	}
+	return bucket, objectName, err
+}
+
+// Deletes an object's record, leaving a tombstone for ListObjectsDeletedSince
+func (b *SiaBridge) deleteObjectRecord(bucket string, objectName string) error {
+	tx, err := g_db.Begin()
     if err != nil {
-    	return err
+    	return fmt.Errorf("deleteObjectRecord: %w", err)
     }
 
-    _, err = stmt.Exec(fetches, bucket, objectName)
+    _, err = tx.Exec("DELETE FROM objects WHERE bucket=? AND name=?", bucket, objectName)
+    if err == nil {
+    	err = insertTombstone(tx, bucket, objectName)
+    }
     if err != nil {
-    	return err
+    	tx.Rollback()
+    	return fmt.Errorf("deleteObjectRecord: %w", err)
     }
 
-    return nil
+    return tx.Commit()
+}
+
+// Deletes the record of an object whose put failed, without a tombstone, since
+// as far as ListObjectsDeletedSince's callers know it never existed
+func (b *SiaBridge) discardObjectRecord(bucket string, objectName string) error {
+	_, err := g_db.Exec("DELETE FROM objects WHERE bucket=? AND name=?", bucket, objectName)
+	if err != nil {
+		return fmt.Errorf("discardObjectRecord: %w", err)
+	}
+	return nil
+}
+
+// Records an operation on an object in the audit log, if EnableAuditLog is set.
+// The operation has already happened, so failures are only passed to
+// managerError.
+func (b *SiaBridge) recordEvent(evType EventType, bucket string, objectName string, detail string) {
+	if !b.EnableAuditLog || b.ReadOnly {
+		return
+	}
+
+	_, err := g_db.Exec("INSERT INTO object_events(at, type, bucket, name, detail) values(?,?,?,?,?)", toMillis(time.Now()), string(evType), bucket, objectName, detail)
+	if err != nil {
+		b.managerError(fmt.Errorf("recordEvent: %w", err))
+	}
+}
+
+// Records that an object was deleted from a bucket
+func insertTombstone(ex execer, bucket string, objectName string) error {
+	_, err := ex.Exec("INSERT INTO deleted_objects(bucket, name, deleted) values(?,?,?)", bucket, objectName, toMillis(time.Now()))
+	return err
+}
+
+// Renames a bucket and moves its objects to the new Sia paths provided, in a
+// single transaction
+func (b *SiaBridge) renameBucketRecords(oldName string, newName string, objects []ObjectInfo, newPaths []string) error {
+	tx, err := g_db.Begin()
+	if err != nil {
+		return fmt.Errorf("renameBucketRecords: %w", err)
+	}
+
+	// Open multipart uploads move with the bucket. Their parts are cached by
+	// upload ID, so don't need to move.
+	_, err = tx.Exec("UPDATE buckets SET name=? WHERE name=?", newName, oldName)
+	if err == nil {
+		_, err = tx.Exec("UPDATE multipart_uploads SET bucket=? WHERE bucket=?", newName, oldName)
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("renameBucketRecords: %w", err)
+	}
+
+	now := toMillis(time.Now())
+	for i, obj := range objects {
+		_, err = tx.Exec("UPDATE objects SET bucket=?, sia_path=?, modified=? WHERE bucket=? AND name=?", newName, newPaths[i], now, oldName, obj.Name)
+		if err == nil {
+			err = insertTombstone(tx, oldName, obj.Name)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("renameBucketRecords: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Moves an object's record to another bucket and Sia path, leaving a
+// tombstone in its old bucket
+func (b *SiaBridge) moveObjectRecord(obj ObjectInfo, bucket string, newPath string) error {
+	tx, err := g_db.Begin()
+	if err != nil {
+		return fmt.Errorf("moveObjectRecord: %w", err)
+	}
+
+	// The destination bucket's quota was checked before its Sia file was
+	// renamed, but other objects may have been added since
+	err = lockBucket(tx, bucket)
+	if err == nil {
+		err = checkQuota(tx, bucket, obj.Size)
+	}
+	if err == ErrQuotaExceeded || err == ErrObjectQuotaExceeded {
+		tx.Rollback()
+		return err
+	}
+
+	if err == nil {
+		_, err = tx.Exec("UPDATE objects SET bucket=?, sia_path=?, modified=? WHERE bucket=? AND name=?", bucket, newPath, toMillis(time.Now()), obj.Bucket, obj.Name)
+	}
+	if err == nil {
+		err = insertTombstone(tx, obj.Bucket, obj.Name)
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("moveObjectRecord: %w", err)
+	}
+
+	return tx.Commit()
 }
 
-func (b *SiaBridge) updateSiaFetches(bucket string, objectName string, fetches int64) error {
-	stmt, err := g_db.Prepare("UPDATE objects SET sia_fetches=? WHERE bucket=? AND name=?")
+// Records a fetch of the object from the cache, which also restarts its
+// PurgeAfter window. The count is incremented by the database rather than
+// written back, so concurrent fetches aren't lost.
+func (b *SiaBridge) updateCachedFetches(bucket string, objectName string) error {
+	if b.ReadOnly {
+		return nil
+	}
+
+	stmt, err := g_db.Prepare("UPDATE objects SET cached_fetches=cached_fetches+1, last_fetch=? WHERE bucket=? AND name=?")
     if err != nil {
-    	return err
+    	return fmt.Errorf("updateCachedFetches: %w", err)
     }
 
-    _, err = stmt.Exec(fetches, bucket, objectName)
+    _, err = stmt.Exec(toMillis(time.Now()), bucket, objectName)
     if err != nil {
-    	return err
+    	return fmt.Errorf("updateCachedFetches: %w", err)
     }
 
     return nil
 }
 
-func (b *SiaBridge) listUploadingObjects() (objects []ObjectInfo, e error) {
-	rows, err := g_db.Query("SELECT bucket,name,size,queued,purge_after,cached_fetches,sia_fetches,last_fetch FROM objects WHERE uploaded=0")
-    if err != nil {
-    	return objects, err
-    }
+func (b *SiaBridge) updatePinned(bucket string, objectName string, pinned bool) error {
+	res, err := g_db.Exec("UPDATE objects SET pinned=?, modified=? WHERE bucket=? AND name=?", boolToInt(pinned), toMillis(time.Now()), bucket, objectName)
+	if err != nil {
+		return fmt.Errorf("updatePinned: %w", err)
+	}
 
-    var bucket string
-    var name string
-    var size int64
-    var queued int64
-    var purge_after int64
-    var cached_fetches int64
-    var sia_fetches int64
-    var last_fetch int64
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("Object does not exist in bucket")
+	}
+	return nil
+}
 
-    for rows.Next() {
-        err = rows.Scan(&bucket, &name, &size, &queued, &purge_after, &cached_fetches, &sia_fetches, &last_fetch)
-        if err != nil {
-        	return objects, err
-        }
+func (b *SiaBridge) updateUnavailableSince(bucket string, objectName string, since *time.Time) error {
+	_, err := g_db.Exec("UPDATE objects SET unavailable_since=?, modified=? WHERE bucket=? AND name=?", nullMillis(since), toMillis(time.Now()), bucket, objectName)
+	if err != nil {
+		return fmt.Errorf("updateUnavailableSince: %w", err)
+	}
+	return nil
+}
 
-        objects = append(objects, ObjectInfo{
-        	Bucket:			bucket,
-    		Name:			name,
-    		Size:       	size,
-    		Queued:         time.Unix(queued, 0),
-    		Uploaded: 		time.Unix(0, 0),
-    		PurgeAfter:		purge_after,
-    		CachedFetches:	cached_fetches,
-    		SiaFetches:		sia_fetches,
-    		LastFetch:  	time.Unix(last_fetch, 0),
-    	})
+func (b *SiaBridge) updateCacheDir(bucket string, objectName string, dir string) error {
+	stmt, err := g_db.Prepare("UPDATE objects SET cache_dir=?, modified=? WHERE bucket=? AND name=?")
+    if err != nil {
+    	return fmt.Errorf("updateCacheDir: %w", err)
     }
 
-    rows.Close()
+    _, err = stmt.Exec(dir, toMillis(time.Now()), bucket, objectName)
+    if err != nil {
+    	return fmt.Errorf("updateCacheDir: %w", err)
+    }
 
-	return objects, nil
+    return nil
 }
 
-func (b *SiaBridge) insertBucket(bucket string) error {
-	stmt, err := g_db.Prepare("INSERT INTO buckets(name, created) values(?,?)")
+// Records new data for the object, which must be uploaded to Sia again
+func (b *SiaBridge) updateObjectData(obj ObjectInfo) error {
+	_, err := g_db.Exec("UPDATE objects SET size=?, stored_size=?, nonce=?, uploaded=NULL, modified=? WHERE bucket=? AND name=?",
+		obj.Size, obj.StoredSize, hex.EncodeToString(obj.Nonce), toMillis(time.Now()), obj.Bucket, obj.Name)
+	if err != nil {
+		return fmt.Errorf("updateObjectData: %w", err)
+	}
+	return nil
+}
+
+// Records a fetch of the object from Sia, as updateCachedFetches does for the
+// cache
+func (b *SiaBridge) updateSiaFetches(bucket string, objectName string) error {
+	if b.ReadOnly {
+		return nil
+	}
+
+	stmt, err := g_db.Prepare("UPDATE objects SET sia_fetches=sia_fetches+1, last_fetch=? WHERE bucket=? AND name=?")
     if err != nil {
-    	return err
+    	return fmt.Errorf("updateSiaFetches: %w", err)
     }
 
-    _, err = stmt.Exec(bucket, time.Now().Unix())
+    _, err = stmt.Exec(toMillis(time.Now()), bucket, objectName)
     if err != nil {
-    	return err
+    	return fmt.Errorf("updateSiaFetches: %w", err)
     }
 
     return nil
 }
 
-func (b *SiaBridge) insertObject(bucket string, objectName string, size int64, queued int64, uploaded int64, purge_after int64) error {
-	stmt, err := g_db.Prepare("INSERT INTO objects(bucket, name, size, queued, uploaded, purge_after, cached_fetches, sia_fetches, last_fetch) values(?,?,?,?,?,?,?,?,?)")
+func (b *SiaBridge) listUploadingObjects() (objects []ObjectInfo, e error) {
+	return b.listObjectsWhere("uploaded IS NULL")
+}
+
+// Returns the objects matching the where clause provided
+func (b *SiaBridge) listObjectsWhere(where string, args ...interface{}) (objects []ObjectInfo, e error) {
+	err := b.forEachObjectWhere(func(obj ObjectInfo) error {
+		objects = append(objects, obj)
+		return nil
+	}, where, args...)
+	return objects, err
+}
+
+// Calls fn for each object matching the where clause provided
+func (b *SiaBridge) forEachObjectWhere(fn func(ObjectInfo) error, where string, args ...interface{}) error {
+	rows, err := g_db.Query("SELECT "+objectColumns+" FROM objects WHERE "+where, args...)
+	if err != nil {
+		return fmt.Errorf("listing objects where %s: %w", where, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		obj, err := scanObject(rows)
+		if err != nil {
+			return fmt.Errorf("scanObject: %w", err)
+		}
+
+		err = fn(obj)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Scans a row of the objects table, selected using objectColumns
+func scanObject(row rowScanner) (obj ObjectInfo, e error) {
+	var queued int64
+	var uploaded sql.NullInt64
+	var last_fetch sql.NullInt64
+	var retain_until sql.NullInt64
+	var compressed int64
+	var nonce string
+	var modified int64
+	var pinned int64
+	var expires_at sql.NullInt64
+	var acl string
+	var unavailable_since sql.NullInt64
+
+	err := row.Scan(&obj.Bucket, &obj.Name, &obj.Size, &queued, &uploaded, &obj.PurgeAfter, &obj.CachedFetches, &obj.SiaFetches, &last_fetch, &obj.CacheDir, &obj.SiaPath, &retain_until, &compressed, &obj.StoredSize, &nonce, &modified, &pinned, &obj.ID, &expires_at, &acl, &obj.Description, &unavailable_since)
+	if err != nil {
+		return obj, err
+	}
+
+	obj.Queued = fromMillis(queued)
+	obj.Uploaded = millisOrNil(uploaded)
+	obj.LastFetch = millisOrNil(last_fetch)
+	obj.RetainUntil = millisOrNil(retain_until)
+	obj.Compressed = compressed != 0
+	obj.Modified = fromMillis(modified)
+	obj.Pinned = pinned != 0
+	obj.ExpiresAt = millisOrNil(expires_at)
+	obj.ACL = ObjectACL(acl)
+	obj.UnavailableSince = millisOrNil(unavailable_since)
+	if nonce != "" {
+		obj.Nonce, err = hex.DecodeString(nonce)
+		if err != nil {
+			return obj, err
+		}
+	}
+	return obj, nil
+}
+
+// Returns the values of objectColumns for an object, in order
+func objectValues(obj ObjectInfo) []interface{} {
+	return []interface{}{
+		obj.Bucket,
+		obj.Name,
+		obj.Size,
+		toMillis(obj.Queued),
+		nullMillis(obj.Uploaded),
+		obj.PurgeAfter,
+		obj.CachedFetches,
+		obj.SiaFetches,
+		nullMillis(obj.LastFetch),
+		obj.CacheDir,
+		obj.SiaPath,
+		nullMillis(obj.RetainUntil),
+		boolToInt(obj.Compressed),
+		obj.StoredSize,
+		hex.EncodeToString(obj.Nonce),
+		toMillis(obj.Modified),
+		boolToInt(obj.Pinned),
+		obj.ID,
+		nullMillis(obj.ExpiresAt),
+		string(obj.ACL),
+		obj.Description,
+		nullMillis(obj.UnavailableSince),
+	}
+}
+
+// Boolean columns are stored as 0 or 1 so they work in every dialect
+func boolToInt(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// Timestamps are stored as milliseconds since the Unix epoch
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Converts a stored timestamp back to a time, in UTC
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// Converts a nullable timestamp column to a time, or nil if it's NULL
+func millisOrNil(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := fromMillis(v.Int64)
+	return &t
+}
+
+// Converts an optional time to a timestamp column value, using NULL for nil
+func nullMillis(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return toMillis(*t)
+}
+
+func (b *SiaBridge) insertBucket(bucket string) error {
+	stmt, err := g_db.Prepare("INSERT INTO buckets(name, created) values(?,?)")
     if err != nil {
-    	return err
+    	return fmt.Errorf("insertBucket: %w", err)
     }
 
-    _, err = stmt.Exec(bucket,
-						objectName,
-						size,
-						queued,
-						uploaded,
-						purge_after,
-						0,
-						0,
-						-1)
+    _, err = stmt.Exec(bucket, toMillis(time.Now()))
     if err != nil {
-    	return err
+    	return fmt.Errorf("insertBucket: %w", err)
     }
 
     return nil
+}
+
+// Inserts a row for the object into the objects table. An object without a
+// modification time is new, so it's modified now, and one without an ID is
+// given one.
+func (b *SiaBridge) insertObject(ex execer, obj ObjectInfo) error {
+	if obj.Modified.IsZero() {
+		obj.Modified = time.Now()
+	}
+	if obj.ID == "" {
+		id, err := newRandomID()
+		if err != nil {
+			return fmt.Errorf("insertObject: %w", err)
+		}
+		obj.ID = id
+	}
+	if obj.ACL == "" {
+		obj.ACL = ACLPrivate
+	}
+	values := objectValues(obj)
+	placeholders := strings.Repeat("?,", len(values)-1) + "?"
+
+	_, err := ex.Exec("INSERT INTO objects("+objectColumns+") values("+placeholders+")", values...)
+	if err != nil {
+		return fmt.Errorf("insertObject: %w", err)
+	}
+	return nil
 }
\ No newline at end of file
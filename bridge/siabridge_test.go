@@ -0,0 +1,941 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/Sia/api"
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// A fake siad holding uploaded files in memory. Uploads complete instantly.
+type fakeSiad struct {
+	mutex sync.Mutex
+	files map[string][]byte
+	rejectUploads bool 	// Fail every upload, as when the renter has no contracts
+	downloadDelay time.Duration // How long each download takes
+	pendingUploads bool // Report every upload as still in progress
+	server *httptest.Server
+}
+
+func newFakeSiad(t testing.TB) *fakeSiad {
+	f := &fakeSiad{files: make(map[string][]byte)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/renter/upload/", f.upload)
+	mux.HandleFunc("/renter/delete/", f.delete)
+	mux.HandleFunc("/renter/files", f.list)
+	mux.HandleFunc("/renter/file/", f.file)
+	mux.HandleFunc("/renter/download/", f.download)
+	mux.HandleFunc("/wallet", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(api.WalletGET{Unlocked: true})
+	})
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func siadFail(w http.ResponseWriter, msg string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(api.Error{Message: msg})
+}
+
+func (f *fakeSiad) upload(w http.ResponseWriter, r *http.Request) {
+	siapath := strings.TrimPrefix(r.URL.Path, "/renter/upload/")
+	r.ParseForm()
+	data, err := ioutil.ReadFile(r.PostForm.Get("source"))
+	if err != nil {
+		siadFail(w, err.Error())
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.rejectUploads {
+		siadFail(w, "not enough contracts to upload file")
+		return
+	}
+	if _, ok := f.files[siapath]; ok {
+		siadFail(w, "a file already exists at that location")
+		return
+	}
+	f.files[siapath] = data
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeSiad) delete(w http.ResponseWriter, r *http.Request) {
+	siapath := strings.TrimPrefix(r.URL.Path, "/renter/delete/")
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.files[siapath]; !ok {
+		siadFail(w, "no file known by that path")
+		return
+	}
+	delete(f.files, siapath)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeSiad) fileInfo(siapath string) modules.FileInfo {
+	info := modules.FileInfo{
+		SiaPath: siapath,
+		Filesize: uint64(len(f.files[siapath])),
+		Available: !f.pendingUploads,
+		Redundancy: 3,
+		UploadProgress: 100,
+	}
+	if f.pendingUploads {
+		info.Redundancy = 0
+		info.UploadProgress = 50
+	}
+	return info
+}
+
+func (f *fakeSiad) list(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var rf api.RenterFiles
+	for siapath := range f.files {
+		rf.Files = append(rf.Files, f.fileInfo(siapath))
+	}
+	json.NewEncoder(w).Encode(rf)
+}
+
+func (f *fakeSiad) file(w http.ResponseWriter, r *http.Request) {
+	siapath := strings.TrimPrefix(r.URL.Path, "/renter/file/")
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.files[siapath]; !ok {
+		siadFail(w, "no file known by that path")
+		return
+	}
+	json.NewEncoder(w).Encode(api.RenterFile{File: f.fileInfo(siapath)})
+}
+
+func (f *fakeSiad) download(w http.ResponseWriter, r *http.Request) {
+	siapath := strings.TrimPrefix(r.URL.Path, "/renter/download/")
+
+	f.mutex.Lock()
+	data, ok := f.files[siapath]
+	delay := f.downloadDelay
+	f.mutex.Unlock()
+	if !ok {
+		siadFail(w, "no file known by that path")
+		return
+	}
+	time.Sleep(delay)
+
+	if r.URL.Query().Get("httpresp") == "true" {
+		w.Write(data)
+		return
+	}
+	err := ioutil.WriteFile(r.URL.Query().Get("destination"), data, 0644)
+	if err != nil {
+		siadFail(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the data siad holds at the siapath, if any
+func (f *fakeSiad) data(siapath string) ([]byte, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	data, ok := f.files[siapath]
+	return data, ok
+}
+
+// Starts a bridge on a new SQLite database and cache directory, talking to a
+// fake siad, with a bucket named "test". The manager is left to the test.
+func newTestBridge(t testing.TB) (*SiaBridge, *fakeSiad) {
+	siad := newFakeSiad(t)
+	dir := t.TempDir()
+
+	b := &SiaBridge{
+		SiadAddress: siad.server.URL,
+		CacheDir: filepath.Join(dir, "cache"),
+		DbFile: filepath.Join(dir, "bridge.db"),
+		ManagerInterval: time.Hour,
+	}
+	err := b.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Stop)
+
+	err = b.CreateBucket("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, siad
+}
+
+// Puts data as an object in the bucket, failing the test on an error
+func mustPut(t testing.TB, b *SiaBridge, bucket string, objectName string, data []byte) ObjectInfo {
+	objInfo, err := b.PutObject(bytes.NewReader(data), bucket, objectName, int64(len(data)), 24*60*60)
+	if err != nil {
+		t.Fatalf("put %s/%s: %v", bucket, objectName, err)
+	}
+	return objInfo
+}
+
+// Returns the names of the staging files left in dir
+func stagingFiles(t testing.TB, dir string) (names []string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".tmp-") {
+			names = append(names, info.Name())
+		}
+	}
+	return names
+}
+
+func TestConcurrentPutsOfSameObject(t *testing.T) {
+	b, _ := newTestBridge(t)
+
+	// Each upload has different data of a different size
+	const n = 8
+	var contents [n][]byte
+	for i := range contents {
+		contents[i] = bytes.Repeat([]byte{byte('a' + i)}, 4096*(i+1))
+	}
+
+	var wg sync.WaitGroup
+	var infos [n]ObjectInfo
+	var errs [n]error
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			infos[i], errs[i] = b.PutObject(bytes.NewReader(contents[i]), "test", "same.txt", int64(len(contents[i])), 24*60*60)
+		}(i)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		switch {
+		case err == nil && winner >= 0:
+			t.Fatalf("uploads %d and %d both succeeded", winner, i)
+		case err == nil:
+			winner = i
+		case err != ErrObjectExists:
+			t.Fatalf("upload %d: got %v, want ErrObjectExists", i, err)
+		}
+	}
+	if winner < 0 {
+		t.Fatal("no upload succeeded")
+	}
+
+	objInfo, err := b.GetObjectInfo("test", "same.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objInfo.Size != int64(len(contents[winner])) {
+		t.Errorf("recorded size %d, want %d", objInfo.Size, len(contents[winner]))
+	}
+
+	var buf bytes.Buffer
+	err = b.GetObject("test", "same.txt", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), contents[winner]) {
+		t.Errorf("cached data isn't the data of the upload that succeeded")
+	}
+
+	if left := stagingFiles(t, filepath.Dir(b.cachePath(objInfo))); len(left) > 0 {
+		t.Errorf("staging files left behind: %v", left)
+	}
+}
+
+func TestAppendToObjectReplacesSiaFile(t *testing.T) {
+	b, siad := newTestBridge(t)
+
+	objInfo := mustPut(t, b, "test", "log.txt", []byte("first\n"))
+	err := b.checkSiaUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.AppendToObject("test", "log.txt", strings.NewReader("second\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, ok := siad.data(objInfo.SiaPath)
+	if !ok || string(data) != "first\nsecond\n" {
+		t.Errorf("Sia has %q, want the grown object", data)
+	}
+
+	objInfo, err = b.GetObjectInfo("test", "log.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objInfo.Size != int64(len("first\nsecond\n")) {
+		t.Errorf("recorded size %d after append", objInfo.Size)
+	}
+	if left := stagingFiles(t, filepath.Dir(b.cachePath(objInfo))); len(left) > 0 {
+		t.Errorf("staging files left behind: %v", left)
+	}
+}
+
+func TestListingPrefixIsCaseSensitive(t *testing.T) {
+	b, _ := newTestBridge(t)
+	for _, name := range []string{"Logs/a.gz", "LOGS/x/y", "logs/b.gz", "logs/c/d.gz"} {
+		mustPut(t, b, "test", name, []byte(name))
+	}
+
+	objects, prefixes, err := b.ListObjectsWithDelimiter("test", "logs/", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Name != "logs/b.gz" {
+		t.Errorf("got objects %v, want just logs/b.gz", objects)
+	}
+	if len(prefixes) != 1 || prefixes[0] != "logs/c/" {
+		t.Errorf("got common prefixes %v, want just logs/c/", prefixes)
+	}
+
+	objects, err = b.ListObjectsGlob("test", "logs/*.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Name != "logs/b.gz" {
+		t.Errorf("got glob matches %v, want just logs/b.gz", objects)
+	}
+}
+
+func TestNamesCantCollide(t *testing.T) {
+	b, _ := newTestBridge(t)
+
+	for _, bucket := range []string{"", ".", "..", MULTIPART_DIR} {
+		if err := b.CreateBucket(bucket); err != ErrInvalidName {
+			t.Errorf("CreateBucket(%q): got %v, want ErrInvalidName", bucket, err)
+		}
+	}
+	for _, name := range []string{"", ".", ".."} {
+		_, err := b.PutObject(strings.NewReader("data"), "test", name, 4, 60)
+		if err != ErrInvalidName {
+			t.Errorf("PutObject(%q): got %v, want ErrInvalidName", name, err)
+		}
+	}
+
+	// Slashes don't let names in different buckets share a path
+	err := b.CreateBucket("test/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := mustPut(t, b, "test", "foo/bar", []byte("first"))
+	second := mustPut(t, b, "test/foo", "bar", []byte("second"))
+	if first.SiaPath == second.SiaPath || b.cachePath(first) == b.cachePath(second) {
+		t.Errorf("test:foo/bar and test/foo:bar share siapath %s", first.SiaPath)
+	}
+
+	// Dotted names that aren't a whole path element are fine
+	for _, name := range []string{"..a", "a/..", "./a", "..."} {
+		objInfo := mustPut(t, b, "test", name, []byte(name))
+		if dir := filepath.Dir(b.cachePath(objInfo)); dir != filepath.Join(b.CacheDir, "test") {
+			t.Errorf("object %q cached in %s", name, dir)
+		}
+	}
+}
+
+func TestConcurrentFetchCounts(t *testing.T) {
+	b, _ := newTestBridge(t)
+	mustPut(t, b, "test", "popular.txt", []byte("data"))
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- b.GetObject("test", "popular.txt", ioutil.Discard)
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- b.updateSiaFetches("test", "popular.txt")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objInfo, err := b.GetObjectInfo("test", "popular.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objInfo.CachedFetches != n || objInfo.SiaFetches != n {
+		t.Errorf("got %d cached and %d Sia fetches, want %d of each", objInfo.CachedFetches, objInfo.SiaFetches, n)
+	}
+}
+
+func TestNameWithSpacesRoundTrip(t *testing.T) {
+	b, siad := newTestBridge(t)
+	const name = "my file (1).txt"
+	data := []byte("contents of my file")
+
+	objInfo := mustPut(t, b, "test", name, data)
+	siapath, err := b.SiaPath("test", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := siad.data(siapath); !ok || !bytes.Equal(got, data) {
+		t.Fatalf("siad has nothing at siapath %q", siapath)
+	}
+	if _, err := os.Stat(b.cachePath(objInfo)); err != nil {
+		t.Fatalf("not cached at %s: %v", b.cachePath(objInfo), err)
+	}
+
+	// Fetch it back from Sia rather than the cache
+	err = b.checkSiaUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(b.cachePath(objInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = b.GetObject("test", name, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("got %q back, want %q", buf.Bytes(), data)
+	}
+}
+
+func TestPutIntoMissingBucket(t *testing.T) {
+	b, _ := newTestBridge(t)
+
+	_, err := b.PutObject(strings.NewReader("data"), "missing", "file.txt", 4, 60)
+	if err != ErrBucketNotFound {
+		t.Fatalf("got %v, want ErrBucketNotFound", err)
+	}
+	if _, err := b.GetBucketInfo("missing"); err != ErrBucketNotFound {
+		t.Errorf("bucket was created by the put")
+	}
+}
+
+func TestOversizedCacheFileIsTruncated(t *testing.T) {
+	b, _ := newTestBridge(t)
+	data := []byte("the real object")
+	objInfo := mustPut(t, b, "test", "grown.txt", data)
+
+	// Something else has written past the end of the cached copy
+	f, err := os.OpenFile(b.cachePath(objInfo), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write([]byte(" and some garbage"))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = b.GetObject("test", "grown.txt", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("GetObject wrote %q, want %q", buf.Bytes(), data)
+	}
+
+	reader, size, err := b.GetObjectReader("test", "grown.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	got, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) || !bytes.Equal(got, data) {
+		t.Errorf("GetObjectReader returned %q of size %d, want %q", got, size, data)
+	}
+}
+
+func TestCopyObjectWhenReadOnly(t *testing.T) {
+	b, _ := newTestBridge(t)
+	objInfo := mustPut(t, b, "test", "original.txt", []byte("data"))
+	err := b.checkSiaUploads()
+	if err == nil {
+		err = os.Remove(b.cachePath(objInfo))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.ReadOnly = true
+	_, err = b.CopyObject("test", "original.txt", "test", "copy.txt")
+	if err != ErrReadOnly {
+		t.Fatalf("got %v, want ErrReadOnly", err)
+	}
+
+	// The source shouldn't have been downloaded for a copy that can't be made
+	if _, err := os.Stat(b.cachePath(objInfo)); err == nil {
+		t.Errorf("source was downloaded into the cache")
+	}
+}
+
+func TestVerifyBucketReportsErrors(t *testing.T) {
+	b, siad := newTestBridge(t)
+	mustPut(t, b, "test", "file.txt", []byte("data"))
+
+	results, err := b.VerifyBucket("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := results["file.txt"]; !ok || err != ErrObjectNotYetAvailable {
+		t.Errorf("got result %v for an uploading object", err)
+	}
+
+	siad.server.Close()
+	_, err = b.VerifyBucket("test")
+	if err == nil {
+		t.Errorf("no error with siad unreachable")
+	}
+}
+
+func TestRelocateCacheDuringPuts(t *testing.T) {
+	b, _ := newTestBridge(t)
+	const n = 30
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < n; i++ {
+			_, err := b.PutObject(strings.NewReader("data"), "test", fmt.Sprintf("object-%d", i), 4, 60)
+			if err == nil {
+				err = b.checkSiaUploads()
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	// Keep moving the cache while the puts run. A put finishing in a
+	// directory that has already been moved away from leaves its object
+	// without a cached copy.
+	base := t.TempDir()
+	for moves := 0; ; moves++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < n; i++ {
+				objInfo, err := b.GetObjectInfo("test", fmt.Sprintf("object-%d", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := os.Stat(b.cachePath(objInfo)); err != nil {
+					t.Errorf("%s isn't in the cache after %d moves: %v", objInfo.Name, moves, err)
+				}
+			}
+			return
+		default:
+		}
+
+		err := b.RelocateCache(filepath.Join(base, strconv.Itoa(moves)))
+		if err != nil && err != ErrUploadInProgress {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFailedPutLeavesNoTombstone(t *testing.T) {
+	b, siad := newTestBridge(t)
+	siad.rejectUploads = true
+	start := time.Now().Add(-time.Second)
+
+	_, err := b.PutObject(strings.NewReader("data"), "test", "file.txt", 4, 60)
+	if err == nil {
+		t.Fatal("put succeeded with siad rejecting uploads")
+	}
+
+	deleted, err := b.ListObjectsDeletedSince("test", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) > 0 {
+		t.Errorf("failed put reported as deleted: %v", deleted)
+	}
+}
+
+func TestBucketLimitsAreIndependent(t *testing.T) {
+	b, _ := newTestBridge(t)
+
+	err := b.SetBucketQuota("test", 1<<20)
+	if err == nil {
+		err = b.SetBucketMaxObjects("test", 2)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bi, err := b.GetBucketInfo("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bi.QuotaBytes != 1<<20 || bi.MaxObjects != 2 {
+		t.Errorf("got quota %d and max objects %d", bi.QuotaBytes, bi.MaxObjects)
+	}
+
+	mustPut(t, b, "test", "one", []byte("1"))
+	mustPut(t, b, "test", "two", []byte("2"))
+	_, err = b.PutObject(strings.NewReader("3"), "test", "three", 1, 60)
+	if err != ErrObjectQuotaExceeded {
+		t.Errorf("third put: got %v, want ErrObjectQuotaExceeded", err)
+	}
+
+	if err := b.SetBucketQuota("missing", 1); err != ErrBucketNotFound {
+		t.Errorf("SetBucketQuota on a missing bucket: got %v", err)
+	}
+}
+
+func TestConcurrentPutsWithinLimits(t *testing.T) {
+	b, _ := newTestBridge(t)
+
+	// Room for three of the objects by size and four by count
+	const n = 8
+	const size = 4096
+	err := b.SetBucketQuota("test", 3*size)
+	if err == nil {
+		err = b.SetBucketMaxObjects("test", 4)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var errs [n]error
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{byte('a' + i)}, size)
+			_, errs[i] = b.PutObject(bytes.NewReader(data), "test", fmt.Sprintf("object-%d", i), size, 24*60*60)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for i, err := range errs {
+		switch err {
+		case nil:
+			succeeded++
+		case ErrQuotaExceeded:
+		default:
+			t.Errorf("upload %d: got %v, want ErrQuotaExceeded", i, err)
+		}
+	}
+	if succeeded != 3 {
+		t.Errorf("%d uploads succeeded, want 3", succeeded)
+	}
+
+	stats, err := b.GetBucketStats("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Bytes > stats.QuotaBytes || stats.Objects > stats.MaxObjects {
+		t.Errorf("bucket holds %d objects of %d bytes", stats.Objects, stats.Bytes)
+	}
+
+	// Small enough to fit by size, so only the count stops them
+	err = b.SetBucketQuota("test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = b.PutObject(strings.NewReader("x"), "test", fmt.Sprintf("small-%d", i), 1, 24*60*60)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded = 0
+	for i, err := range errs {
+		switch err {
+		case nil:
+			succeeded++
+		case ErrObjectQuotaExceeded:
+		default:
+			t.Errorf("small upload %d: got %v, want ErrObjectQuotaExceeded", i, err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d small uploads succeeded, want 1", succeeded)
+	}
+}
+
+func TestRenameBucketMovesMultipartUploads(t *testing.T) {
+	b, _ := newTestBridge(t)
+
+	uploadID, err := b.InitMultipartUpload("test", "parts.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.UploadPart(uploadID, 1, strings.NewReader("hello "), 6)
+	if err == nil {
+		err = b.UploadPart(uploadID, 2, strings.NewReader("world"), 5)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.RenameBucket("test", "renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket, _, err := b.getMultipartUpload(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket != "renamed" {
+		t.Errorf("upload is in bucket %q, want renamed", bucket)
+	}
+
+	err = b.CompleteMultipartUpload(uploadID, 24*60*60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objInfo, err := b.GetObjectInfo("renamed", "parts.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objInfo.Size != 11 {
+		t.Errorf("recorded size %d, want 11", objInfo.Size)
+	}
+}
+
+func TestRebuildSkipsInvalidNames(t *testing.T) {
+	b, siad := newTestBridge(t)
+
+	siad.mutex.Lock()
+	siad.files["rebuilt/one.txt"] = []byte("one")
+	siad.files["noslash"] = []byte("x")
+	siad.files[MULTIPART_DIR+"/part"] = []byte("x")
+	siad.files["rebuilt/.."] = []byte("x")
+	siad.mutex.Unlock()
+
+	skipped, err := b.RebuildFromSia()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{"noslash": true, MULTIPART_DIR+"/part": true, "rebuilt/..": true}
+	if len(skipped) != len(want) {
+		t.Errorf("skipped %v", skipped)
+	}
+	for _, siapath := range skipped {
+		if !want[siapath] {
+			t.Errorf("skipped %s", siapath)
+		}
+	}
+
+	objInfo, err := b.GetObjectInfo("rebuilt", "one.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objInfo.Size != 3 {
+		t.Errorf("recorded size %d, want 3", objInfo.Size)
+	}
+}
+
+func TestStopEndsUploadStream(t *testing.T) {
+	b, siad := newTestBridge(t)
+	siad.pendingUploads = true
+
+	events, err := b.PutObjectFromReaderStream(strings.NewReader("data"), "test", "stream.txt", 4, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-events; ev.Type != UploadCached {
+		t.Fatalf("first event %v, want UploadCached", ev.Type)
+	}
+	if ev := <-events; ev.Type != UploadProgress {
+		t.Fatalf("second event %v, want UploadProgress", ev.Type)
+	}
+
+	b.Stop()
+
+	select {
+	case ev := <-events:
+		if ev.Type != UploadFailed || ev.Err != ErrBridgeStopped {
+			t.Errorf("got event %v with error %v, want UploadFailed with ErrBridgeStopped", ev.Type, ev.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("upload still watched after Stop")
+	}
+	if _, ok := <-events; ok {
+		t.Error("events not closed")
+	}
+}
+
+func TestReconcileCacheReportsCounts(t *testing.T) {
+	b, _ := newTestBridge(t)
+
+	obj := mustPut(t, b, "test", "uncached.txt", []byte("data"))
+	mustPut(t, b, "test", "cached.txt", []byte("data"))
+	err := os.Remove(b.cachePath(obj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(b.cacheDir(), "orphan")
+	err = ioutil.WriteFile(orphan, []byte("x"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := b.ReconcileCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Removed != 1 || result.NotCached != 0 {
+		t.Errorf("removed %d and found %d not cached, want 1 and 0", result.Removed, result.NotCached)
+	}
+	if len(result.Missing) != 1 || result.Missing[0] != "test/uncached.txt" {
+		t.Errorf("got missing %v", result.Missing)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("orphaned file not removed")
+	}
+}
+
+func TestCacheMethodsWhenReadOnly(t *testing.T) {
+	b, _ := newTestBridge(t)
+	b.ReadOnly = true
+
+	if _, err := b.ReclaimCache(1); err != ErrReadOnly {
+		t.Errorf("ReclaimCache: got %v", err)
+	}
+	if _, err := b.PurgeBucketCache("test"); err != ErrReadOnly {
+		t.Errorf("PurgeBucketCache: got %v", err)
+	}
+	if _, err := b.ReconcileCache(); err != ErrReadOnly {
+		t.Errorf("ReconcileCache: got %v", err)
+	}
+	oldDir := b.CacheDir
+	if err := b.RelocateCache(t.TempDir()); err != ErrReadOnly || b.CacheDir != oldDir {
+		t.Errorf("RelocateCache: got %v, CacheDir %s", err, b.CacheDir)
+	}
+}
+
+func TestTimedOutDownloadIsCleanedUp(t *testing.T) {
+	b, siad := newTestBridge(t)
+	objInfo := mustPut(t, b, "test", "slow.txt", []byte("data"))
+	err := b.checkSiaUploads()
+	if err == nil {
+		err = os.Remove(b.cachePath(objInfo))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	siad.mutex.Lock()
+	siad.downloadDelay = 300 * time.Millisecond
+	siad.mutex.Unlock()
+	b.DownloadTimeout = 50 * time.Millisecond
+
+	err = b.GetObject("test", "slow.txt", ioutil.Discard)
+	if !errors.Is(err, ErrDownloadTimeout) {
+		t.Fatalf("got %v, want ErrDownloadTimeout", err)
+	}
+
+	// Siad is still writing the staged file, which is removed once it's done
+	dir := filepath.Dir(b.cachePath(objInfo))
+	if len(stagingFiles(t, dir)) == 0 {
+		t.Errorf("staged file removed while siad was still writing it")
+	}
+	time.Sleep(600 * time.Millisecond)
+	if left := stagingFiles(t, dir); len(left) > 0 {
+		t.Errorf("staging files left behind: %v", left)
+	}
+}
+
+func TestFetchesDontCountAsModifications(t *testing.T) {
+	b, _ := newTestBridge(t)
+	mustPut(t, b, "test", "read.txt", []byte("data"))
+
+	// Modification times are in milliseconds
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	err := b.GetObject("test", "read.txt", ioutil.Discard)
+	if err == nil {
+		err = b.updateSiaFetches("test", "read.txt")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := b.ListObjectsModifiedSince("test", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) > 0 {
+		t.Errorf("fetched object listed as modified: %v", changed)
+	}
+}
+
+func TestCopyObjectUploadsNewSiaFile(t *testing.T) {
+	b, siad := newTestBridge(t)
+	src := mustPut(t, b, "test", "original.txt", []byte("same data"))
+
+	dst, err := b.CopyObject("test", "original.txt", "test", "copy.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.SiaPath == src.SiaPath {
+		t.Fatalf("copy shares the source's siapath %s", src.SiaPath)
+	}
+	if data, ok := siad.data(dst.SiaPath); !ok || string(data) != "same data" {
+		t.Errorf("copy wasn't uploaded to Sia as a file of its own")
+	}
+
+	// Deleting the source leaves the copy's file
+	err = b.checkSiaUploads()
+	if err == nil {
+		err = b.DeleteObject("test", "original.txt")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := siad.data(dst.SiaPath); !ok {
+		t.Errorf("deleting the source deleted the copy's Sia file")
+	}
+}
@@ -59,9 +59,9 @@ func createTestFile(path, text string) error {
 }
 
 func main() {
-	g_siab := &bridge.SiaBridge{"127.0.0.1:9980",
-								".sia_cache",
-	                            "siabridge.db"}
+	g_siab := &bridge.SiaBridge{SiadAddress: "127.0.0.1:9980",
+								CacheDir: ".sia_cache",
+	                            DbFile: "siabridge.db"}
 
 	err := g_siab.Start()
 	checkError(err)
@@ -110,7 +110,7 @@ func main() {
 
 	objInfo, err := g_siab.GetObjectInfo("TestBucket1", "TestFile1.txt")
 	checkError(err)
-	for objInfo.Uploaded == time.Unix(0,0) {
+	for !objInfo.IsUploaded() {
 		fmt.Println("Waiting for TestFile1.txt to upload to Sia...")
 		time.Sleep(time.Millisecond * 5000)
 		objInfo, err = g_siab.GetObjectInfo("TestBucket1", "TestFile1.txt")